@@ -0,0 +1,225 @@
+package gdpr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// AdminPrefix is the path under which the admin API is
+// served. Setting ServerOptions.AdminAuthenticator opts
+// every route beneath it into authentication, including
+// routes supplied through ServerOptions.HandlerMap.
+// Without it the admin API is not served and such
+// routes are not authenticated.
+const AdminPrefix = "/admin/"
+
+// RequestDetails is everything a
+// processor knows about a request.
+type RequestDetails struct {
+	Request *Request        `json:"request"`
+	Status  *StatusResponse `json:"status"`
+	// Optional free form history of the request
+	// such as admin actions and their justification.
+	History []string `json:"history,omitempty"`
+}
+
+// TransitionRequest forces a request into a status.
+type TransitionRequest struct {
+	RequestStatus RequestStatus `json:"request_status"`
+	Reason        string        `json:"reason"`
+}
+
+// DeadlineRequest extends the expected
+// completion time of a request.
+type DeadlineRequest struct {
+	ExpectedCompletionTime time.Time `json:"expected_completion_time"`
+	Reason                 string    `json:"reason"`
+}
+
+// CallbackResult reports the outcome of re-sending
+// a callback to a single status callback URL.
+type CallbackResult struct {
+	StatusCallbackUrl string `json:"status_callback_url"`
+	Error             string `json:"error,omitempty"`
+}
+
+// Administrator is an optional interface a Processor may
+// implement to support operating on requests outside of the
+// OpenGDPR specification. When the Processor is an
+// Administrator and ServerOptions.AdminAuthenticator is
+// set the admin API is served beneath AdminPrefix.
+type Administrator interface {
+	// Details returns everything known
+	// about the request.
+	Details(id string) (*RequestDetails, error)
+	// Transition forces the request into the given
+	// status regardless of it's current status.
+	Transition(id string, req *TransitionRequest) (*StatusResponse, error)
+	// ExtendDeadline moves the expected
+	// completion time of the request.
+	ExtendDeadline(id string, req *DeadlineRequest) (*StatusResponse, error)
+}
+
+// admin/requests
+
+func getAdminRequest(opts *ServerOptions) Handler {
+	return func(w io.Writer, _ io.Reader, p httprouter.Params) error {
+		resp, err := opts.Processor.(Administrator).Details(p.ByName("id"))
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func postAdminTransition(opts *ServerOptions) Handler {
+	return func(w io.Writer, r io.Reader, p httprouter.Params) error {
+		req := &TransitionRequest{}
+		err := decodeJSON(r, req, opts.StrictJSON)
+		if err != nil {
+			return err
+		}
+		if req.RequestStatus == "" {
+			return ErrMissingRequiredField("request_status")
+		}
+		if strings.TrimSpace(req.Reason) == "" {
+			return ErrMissingRequiredField("reason")
+		}
+		resp, err := opts.Processor.(Administrator).Transition(p.ByName("id"), req)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func postAdminDeadline(opts *ServerOptions) Handler {
+	return func(w io.Writer, r io.Reader, p httprouter.Params) error {
+		req := &DeadlineRequest{}
+		err := decodeJSON(r, req, opts.StrictJSON)
+		if err != nil {
+			return err
+		}
+		if req.ExpectedCompletionTime.IsZero() {
+			return ErrMissingRequiredField("expected_completion_time")
+		}
+		if strings.TrimSpace(req.Reason) == "" {
+			return ErrMissingRequiredField("reason")
+		}
+		resp, err := opts.Processor.(Administrator).ExtendDeadline(p.ByName("id"), req)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// postAdminCallback re-sends the current status of a
+// request to each of it's status callback URLs, giving
+// up once the admin request is cancelled.
+func postAdminCallback(opts *ServerOptions) Handler {
+	return func(w io.Writer, r io.Reader, p httprouter.Params) error {
+		if opts.AdminCallback == nil {
+			return ErrorResponse{Code: http.StatusNotImplemented, Message: "callbacks are not configured"}
+		}
+		details, err := opts.Processor.(Administrator).Details(p.ByName("id"))
+		if err != nil {
+			return err
+		}
+		if details.Request == nil || details.Status == nil {
+			return fmt.Errorf("incomplete details of request %s", p.ByName("id"))
+		}
+		results := []*CallbackResult{}
+		for _, cbUrl := range details.Request.StatusCallbackUrls {
+			result := &CallbackResult{StatusCallbackUrl: cbUrl}
+			err := CallbackContext(requestContext(r), &CallbackRequest{
+				ControllerId:           details.Status.ControllerId,
+				ExpectedCompletionTime: details.Status.ExpectedCompletionTime,
+				StatusCallbackUrl:      cbUrl,
+				SubjectRequestId:       details.Status.SubjectRequestId,
+				RequestStatus:          details.Status.RequestStatus,
+				ResultsUrl:             details.Status.ResultsUrl,
+			}, opts.AdminCallback)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+		return json.NewEncoder(w).Encode(results)
+	}
+}
+
+// adminHandlerMap returns the routes of the admin API.
+func adminHandlerMap() HandlerMap {
+	return HandlerMap{
+		AdminPrefix + "requests/:id": map[string]Builder{
+			"GET": getAdminRequest,
+		},
+		AdminPrefix + "requests/:id/transition": map[string]Builder{
+			"POST": postAdminTransition,
+		},
+		AdminPrefix + "requests/:id/deadline": map[string]Builder{
+			"POST": postAdminDeadline,
+		},
+		AdminPrefix + "requests/:id/callback": map[string]Builder{
+			"POST": postAdminCallback,
+		},
+	}
+}
+
+// AdminClient is an HTTP client for
+// the admin API of a processor.
+type AdminClient struct {
+	client *Client
+}
+
+// NewAdminClient returns a new AdminClient. The Caller
+// must add whatever credentials the processor's
+// AdminAuthenticator expects.
+func NewAdminClient(opts *ClientOptions) *AdminClient {
+	return &AdminClient{client: NewClient(opts)}
+}
+
+func (a *AdminClient) post(ctx context.Context, path string, body, v interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return a.client.do(ctx, "POST", path, raw, true, false, v)
+}
+
+// Details returns everything the
+// processor knows about a request.
+func (a *AdminClient) Details(ctx context.Context, id string) (*RequestDetails, error) {
+	details := &RequestDetails{}
+	return details, a.client.do(ctx, "GET", AdminPrefix+"requests/"+id, nil, true, true, details)
+}
+
+// Transition forces a request into the given status.
+func (a *AdminClient) Transition(ctx context.Context, id string, status RequestStatus, reason string) (*StatusResponse, error) {
+	statResp := &StatusResponse{}
+	req := &TransitionRequest{RequestStatus: status, Reason: reason}
+	return statResp, a.post(ctx, AdminPrefix+"requests/"+id+"/transition", req, statResp)
+}
+
+// ExtendDeadline moves the expected
+// completion time of a request.
+func (a *AdminClient) ExtendDeadline(ctx context.Context, id string, until time.Time, reason string) (*StatusResponse, error) {
+	statResp := &StatusResponse{}
+	req := &DeadlineRequest{ExpectedCompletionTime: until, Reason: reason}
+	return statResp, a.post(ctx, AdminPrefix+"requests/"+id+"/deadline", req, statResp)
+}
+
+// ResendCallback sends the current status of a request
+// to each of it's status callback URLs again.
+func (a *AdminClient) ResendCallback(ctx context.Context, id string) ([]*CallbackResult, error) {
+	var results []*CallbackResult
+	return results, a.post(ctx, AdminPrefix+"requests/"+id+"/callback", struct{}{}, &results)
+}
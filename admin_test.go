@@ -0,0 +1,192 @@
+package gdpr
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockAdministrator struct {
+	mockProcessor
+	details map[string]*RequestDetails
+}
+
+func (m *mockAdministrator) Details(id string) (*RequestDetails, error) {
+	details, ok := m.details[id]
+	if !ok {
+		return nil, ErrNotFound(id)
+	}
+	return details, nil
+}
+
+func (m *mockAdministrator) Transition(id string, req *TransitionRequest) (*StatusResponse, error) {
+	details, err := m.Details(id)
+	if err != nil {
+		return nil, err
+	}
+	details.Status.RequestStatus = req.RequestStatus
+	details.History = append(details.History, req.Reason)
+	return details.Status, nil
+}
+
+func (m *mockAdministrator) ExtendDeadline(id string, req *DeadlineRequest) (*StatusResponse, error) {
+	details, err := m.Details(id)
+	if err != nil {
+		return nil, err
+	}
+	details.Status.ExpectedCompletionTime = req.ExpectedCompletionTime
+	details.History = append(details.History, req.Reason)
+	return details.Status, nil
+}
+
+func TestAdminClient(t *testing.T) {
+	controller := &mockController{}
+	ctrlSvr := httptest.NewServer(NewServer(&ServerOptions{Controller: controller, Verifier: NoopVerifier{}}))
+	defer ctrlSvr.Close()
+	proc := &mockAdministrator{details: map[string]*RequestDetails{
+		"1234": &RequestDetails{
+			Request: &Request{
+				SubjectRequestId:   "1234",
+				SubjectRequestType: SUBJECT_ERASURE,
+				StatusCallbackUrls: []string{ctrlSvr.URL + "/opengdpr_callbacks", "http://127.0.0.1:1/opengdpr_callbacks"},
+			},
+			Status: &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_PENDING},
+		},
+	}}
+	server := NewServer(&ServerOptions{
+		Signer:        NoopSigner{},
+		Processor:     proc,
+		AdminCallback: &CallbackOptions{MaxAttempts: 1, Signer: NoopSigner{}},
+		AdminAuthenticator: AuthenticatorFunc(func(r *http.Request) (string, error) {
+			if r.Header.Get("Authorization") != "Bearer admin" {
+				return "", ErrUnauthorized("bad token")
+			}
+			return "operator", nil
+		}),
+	})
+	svr := httptest.NewServer(server)
+	defer svr.Close()
+	ctx := context.Background()
+	admin := NewAdminClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}})
+	_, err := admin.Details(ctx, "1234")
+	assert.Equal(t, 401, err.(*ErrorResponse).Code)
+	admin = NewAdminClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set("Authorization", "Bearer admin")
+			return http.DefaultClient.Do(r)
+		}),
+	})
+	details, err := admin.Details(ctx, "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_PENDING, details.Status.RequestStatus)
+	_, err = admin.Details(ctx, "4321")
+	assert.Equal(t, 404, err.(*ErrorResponse).Code)
+	// A justification is required
+	_, err = admin.Transition(ctx, "1234", STATUS_IN_PROGRESS, "")
+	assert.Equal(t, 400, err.(*ErrorResponse).Code)
+	status, err := admin.Transition(ctx, "1234", STATUS_IN_PROGRESS, "stuck in queue")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_IN_PROGRESS, status.RequestStatus)
+	deadline := time.Date(2018, 10, 2, 15, 0, 0, 0, time.UTC)
+	status, err = admin.ExtendDeadline(ctx, "1234", deadline, "backlog")
+	assert.NoError(t, err)
+	assert.True(t, deadline.Equal(status.ExpectedCompletionTime))
+	assert.Equal(t, []string{"stuck in queue", "backlog"}, proc.details["1234"].History)
+	results, err := admin.ResendCallback(ctx, "1234")
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Empty(t, results[0].Error)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Len(t, controller.callbacks, 1)
+	assert.Equal(t, STATUS_IN_PROGRESS, controller.callbacks[0].RequestStatus)
+	// The admin API is not served without an authenticator
+	server = NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: proc})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/admin/requests/1234", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestAdminCustomRoutes(t *testing.T) {
+	opts := &ServerOptions{
+		Signer:    NoopSigner{},
+		Processor: &mockProcessor{},
+		HandlerMap: HandlerMap{
+			AdminPrefix + "custom": map[string]Builder{
+				"GET": func(*ServerOptions) Handler {
+					return func(w io.Writer, _ io.Reader, _ httprouter.Params) error {
+						_, err := w.Write([]byte("{}"))
+						return err
+					}
+				},
+			},
+		},
+	}
+	// Routes under the admin prefix supplied through the
+	// HandlerMap are not authenticated without an
+	// AdminAuthenticator
+	server := NewServer(opts)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", AdminPrefix+"custom", nil))
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", AdminPrefix+"requests/1234", nil))
+	assert.Equal(t, 404, w.Code)
+	// But require it once one is set
+	opts.AdminAuthenticator = AuthenticatorFunc(func(r *http.Request) (string, error) {
+		return "", ErrUnauthorized("bad token")
+	})
+	server = NewServer(opts)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", AdminPrefix+"custom", nil))
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestAdminCallbackCancelled(t *testing.T) {
+	// A callback URL which never responds
+	block := make(chan struct{})
+	ctrlSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-block:
+		}
+	}))
+	defer ctrlSvr.Close()
+	defer close(block)
+	proc := &mockAdministrator{details: map[string]*RequestDetails{
+		"1234": &RequestDetails{
+			Request: &Request{SubjectRequestId: "1234", StatusCallbackUrls: []string{ctrlSvr.URL}},
+			Status:  &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_PENDING},
+		},
+	}}
+	server := NewServer(&ServerOptions{
+		Signer:        NoopSigner{},
+		Processor:     proc,
+		AdminCallback: &CallbackOptions{MaxAttempts: 1, Signer: NoopSigner{}},
+		AdminAuthenticator: AuthenticatorFunc(func(r *http.Request) (string, error) {
+			return "operator", nil
+		}),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("POST", AdminPrefix+"requests/1234/callback", strings.NewReader("{}")).WithContext(ctx)
+	w := httptest.NewRecorder()
+	// The callback is abandoned with the admin request
+	start := time.Now()
+	server.ServeHTTP(w, r)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, 200, w.Code)
+	var results []*CallbackResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Len(t, results, 1)
+	assert.NotEmpty(t, results[0].Error)
+}
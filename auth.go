@@ -0,0 +1,208 @@
+package gdpr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// ControllerIdHeader identifies the
+	// controller signing a request.
+	ControllerIdHeader = "X-OpenGDPR-Controller-Id"
+	// ApiKeyHeader carries an API key as an
+	// alternative to an Authorization bearer token.
+	ApiKeyHeader = "X-API-Key"
+)
+
+// ControllerIdParam is the key of the httprouter.Params
+// entry holding the identity of the caller returned
+// by an Authenticator.
+const ControllerIdParam = "controller_id"
+
+// Authenticator authenticates incoming requests returning
+// the identity of the caller, e.g. a controller id.
+// Authenticate should return an ErrorResponse such as
+// ErrUnauthorized when the request is rejected.
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// AuthenticatorFunc adapts an ordinary
+// function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+func (fn AuthenticatorFunc) Authenticate(r *http.Request) (string, error) { return fn(r) }
+
+// NewTokenAuthenticator returns an Authenticator accepting
+// bearer tokens in the Authorization header or API keys in
+// the ApiKeyHeader. tokens maps each token to the identity
+// of the controller it was issued to.
+func NewTokenAuthenticator(tokens map[string]string) Authenticator {
+	// Compare digests so lookups take the same
+	// time regardless of the token given.
+	digests := map[[sha256.Size]byte]string{}
+	for token, id := range tokens {
+		digests[sha256.Sum256([]byte(token))] = id
+	}
+	return AuthenticatorFunc(func(r *http.Request) (string, error) {
+		token := r.Header.Get(ApiKeyHeader)
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if token == "" {
+			return "", ErrUnauthorized("missing token")
+		}
+		digest := sha256.Sum256([]byte(token))
+		for known, id := range digests {
+			if subtle.ConstantTimeCompare(known[:], digest[:]) == 1 {
+				return id, nil
+			}
+		}
+		return "", ErrUnauthorized("unknown token")
+	})
+}
+
+// NewCertificateAuthenticator returns an Authenticator for
+// mutual TLS. The common name of a verified client
+// certificate is mapped to a controller identity with
+// controllers. The http.Server must be configured to
+// request and verify client certificates.
+func NewCertificateAuthenticator(controllers map[string]string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (string, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return "", ErrUnauthorized("missing client certificate")
+		}
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		id, ok := controllers[name]
+		if !ok {
+			return "", ErrUnauthorized(fmt.Sprintf("unknown client certificate: %s", name))
+		}
+		return id, nil
+	})
+}
+
+// RequestSigningPayload returns the bytes covered by the
+// signature of a controller signed request.
+func RequestSigningPayload(method, uri string, body []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(method)
+	buf.WriteString(" ")
+	buf.WriteString(uri)
+	buf.WriteString("\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// NewSignatureAuthenticator returns an Authenticator for
+// requests signed by a controller, see ClientOptions.Signer.
+// The controller is identified by the ControllerIdHeader and
+// verified with it's Verifier from verifiers. If replay is set
+// the signature must also cover a fresh timestamp and nonce.
+func NewSignatureAuthenticator(verifiers map[string]Verifier, replay *ReplayOptions) Authenticator {
+	replay = newReplayOptions(replay)
+	return AuthenticatorFunc(func(r *http.Request) (string, error) {
+		id := r.Header.Get(ControllerIdHeader)
+		verifier, ok := verifiers[id]
+		if !ok {
+			return "", ErrUnauthorized(fmt.Sprintf("unknown controller: %s", id))
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		// Restore the body for the handler
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			verifier, body, err = canonicalVerifierPayload(verifier, body)
+			if err != nil {
+				return "", ErrInvalidRequestSignature(r.Header.Get("X-OpenGDPR-Signature"), err)
+			}
+		}
+		payload := RequestSigningPayload(r.Method, r.URL.RequestURI(), body)
+		if replay != nil {
+			err = replay.verify(verifier, r.Header, payload)
+		} else {
+			err = verifier.Verify(payload, r.Header.Get("X-OpenGDPR-Signature"))
+		}
+		if err != nil {
+			if _, ok := err.(ErrorResponse); ok {
+				return "", err
+			}
+			return "", ErrInvalidRequestSignature(r.Header.Get("X-OpenGDPR-Signature"), err)
+		}
+		return id, nil
+	})
+}
+
+// AnyAuthenticator returns an Authenticator accepting
+// requests accepted by any of auths which are tried in
+// order. The error of the last Authenticator is returned
+// when none accept the request.
+func AnyAuthenticator(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (string, error) {
+		err := ErrUnauthorized("no authenticator configured")
+		for _, auth := range auths {
+			var id string
+			id, err = auth.Authenticate(r)
+			if err == nil {
+				return id, nil
+			}
+		}
+		return "", err
+	})
+}
+
+// authenticatedRoutes are the routes and methods
+// which require an Authenticator.
+var authenticatedRoutes = map[string]map[string]bool{
+	"/opengdpr_requests": {"GET": true},
+}
+
+// controllerRoutes are the routes and methods called by
+// controllers which are authenticated when
+// ServerOptions.ControllerAuthenticator is set.
+var controllerRoutes = map[string]map[string]bool{
+	"/opengdpr_requests":     {"POST": true},
+	"/opengdpr_requests/:id": {"GET": true, "DELETE": true},
+	"/opengdpr_statuses":     {"POST": true},
+}
+
+// routeAuthenticator returns the Authenticator of a
+// route and whether the route requires authentication.
+func (s *Server) routeAuthenticator(path, method string) (Authenticator, bool) {
+	switch {
+	case strings.HasPrefix(path, AdminPrefix) && s.adminAuthenticator != nil:
+		return s.adminAuthenticator, true
+	case authenticatedRoutes[path][method]:
+		return s.authenticator, true
+	case controllerRoutes[path][method] && s.controllerAuthenticator != nil:
+		return s.controllerAuthenticator, true
+	}
+	return nil, false
+}
+
+// authenticate rejects requests which are not accepted
+// by the Authenticator and passes the identity of the
+// caller to the handler as the ControllerIdParam.
+func (s *Server) authenticate(auth Authenticator, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		var id string
+		err := ErrUnauthorized("no authenticator configured")
+		if auth != nil {
+			id, err = auth.Authenticate(r)
+		}
+		if err != nil {
+			s.setHeaders(w)
+			s.error(w, err)
+			return
+		}
+		next(w, r, append(p, httprouter.Param{Key: ControllerIdParam, Value: id}))
+	}
+}
@@ -0,0 +1,143 @@
+package gdpr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingProcessor struct {
+	mockProcessor
+	requests []*Request
+}
+
+func (r *recordingProcessor) Request(req *Request) (*Response, error) {
+	r.requests = append(r.requests, req)
+	return &Response{SubjectRequestId: req.SubjectRequestId, ControllerId: "unauthenticated"}, nil
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	auth := NewTokenAuthenticator(map[string]string{"secret": "controller.com"})
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := auth.Authenticate(r)
+	assert.Error(t, err)
+	r.Header.Set("Authorization", "Bearer secret")
+	id, err := auth.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "controller.com", id)
+	r.Header.Set("Authorization", "Bearer other")
+	_, err = auth.Authenticate(r)
+	assert.Equal(t, 401, err.(ErrorResponse).Code)
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(ApiKeyHeader, "secret")
+	id, err = auth.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "controller.com", id)
+}
+
+func TestCertificateAuthenticator(t *testing.T) {
+	auth := NewCertificateAuthenticator(map[string]string{"controller-client": "controller.com"})
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := auth.Authenticate(r)
+	assert.Error(t, err)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "controller-client"}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	id, err := auth.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "controller.com", id)
+	cert.Subject.CommonName = "other"
+	_, err = auth.Authenticate(r)
+	assert.Error(t, err)
+	// Any authenticator may accept the request
+	r.Header.Set(ApiKeyHeader, "secret")
+	id, err = AnyAuthenticator(auth, NewTokenAuthenticator(map[string]string{"secret": "other.com"})).Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "other.com", id)
+}
+
+func TestServerControllerAuthenticator(t *testing.T) {
+	proc := &recordingProcessor{mockProcessor: mockProcessor{
+		statusResponse: &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_PENDING, ControllerId: "controller.com"},
+	}}
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		Submissions:  NewMemorySubmissionStore(),
+		Replay:       &ReplayOptions{},
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		ControllerAuthenticator: NewSignatureAuthenticator(map[string]Verifier{
+			"controller.com": MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+			"other.com":      MustNewVerifier(&KeyOptions{KeyBytes: keyPairTwo[1]}),
+		}, &ReplayOptions{}),
+	})
+	svr := httptest.NewServer(server)
+	defer svr.Close()
+	req := &Request{
+		SubjectRequestId:   "1234",
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	}
+	ctx := context.Background()
+	// Unsigned
+	client := NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}})
+	_, err := client.Request(ctx, req)
+	assert.Equal(t, 401, err.(*ErrorResponse).Code)
+	_, err = client.Status(ctx, "1234")
+	assert.Equal(t, 401, err.(*ErrorResponse).Code)
+	// Signed by an unknown key
+	client = NewClient(&ClientOptions{
+		Endpoint:     svr.URL,
+		Verifier:     NoopVerifier{},
+		Signer:       MustNewSigner(&KeyOptions{KeyBytes: keyPairTwo[0]}),
+		ControllerId: "controller.com",
+		Replay:       &ReplayOptions{},
+	})
+	_, err = client.Request(ctx, req)
+	assert.Equal(t, 403, err.(*ErrorResponse).Code)
+	assert.Len(t, proc.requests, 0)
+	client = NewClient(&ClientOptions{
+		Endpoint:     svr.URL,
+		Verifier:     NoopVerifier{},
+		Signer:       MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+		ControllerId: "controller.com",
+		Replay:       &ReplayOptions{},
+	})
+	resp, err := client.Request(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "controller.com", resp.ControllerId)
+	assert.Len(t, proc.requests, 1)
+	assert.Equal(t, "controller.com", proc.requests[0].ControllerId)
+	status, err := client.Status(ctx, "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_PENDING, status.RequestStatus)
+	// Requests of another controller are not revealed
+	other := NewClient(&ClientOptions{
+		Endpoint:     svr.URL,
+		Verifier:     NoopVerifier{},
+		Signer:       MustNewSigner(&KeyOptions{KeyBytes: keyPairTwo[0]}),
+		ControllerId: "other.com",
+		Replay:       &ReplayOptions{},
+	})
+	_, err = other.Status(ctx, "1234")
+	assert.Equal(t, 404, err.(*ErrorResponse).Code)
+	_, err = other.Cancel(ctx, "1234")
+	assert.Equal(t, 404, err.(*ErrorResponse).Code)
+	statuses, err := other.StatusMany(ctx, []string{"1234"})
+	assert.NoError(t, err)
+	assert.Nil(t, statuses[0].Status)
+	assert.Equal(t, 404, statuses[0].Error.Code)
+	// and the same subject_request_id does not conflict
+	_, err = other.Request(ctx, req)
+	assert.NoError(t, err)
+	assert.Len(t, proc.requests, 2)
+	assert.Equal(t, "other.com", proc.requests[1].ControllerId)
+	// Discovery remains public
+	_, err = NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}, Replay: &ReplayOptions{}}).Discovery(ctx)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,255 @@
+package gdpr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const (
+	// MaxBatchSize is the largest number of requests
+	// whose status can be queried in a single batch.
+	MaxBatchSize = 1000
+	// DefaultStatusConcurrency is the number of parallel
+	// status calls made by Client.StatusMany against
+	// processors which do not serve batch queries.
+	DefaultStatusConcurrency = 10
+)
+
+// BatchStatusRequest queries the
+// status of many requests at once.
+type BatchStatusRequest struct {
+	SubjectRequestIds []string `json:"subject_request_ids"`
+}
+
+// BatchStatus is the status of a single request
+// in a batch or the error preventing it from
+// being returned.
+type BatchStatus struct {
+	SubjectRequestId string          `json:"subject_request_id"`
+	Status           *StatusResponse `json:"status,omitempty"`
+	Error            *ErrorResponse  `json:"error,omitempty"`
+}
+
+// batchStatusJSON is the wire form of a BatchStatus, it's
+// error is not wrapped in a further "error" object.
+type batchStatusJSON struct {
+	SubjectRequestId string          `json:"subject_request_id"`
+	Status           *StatusResponse `json:"status,omitempty"`
+	Error            *errMsgInner    `json:"error,omitempty"`
+}
+
+func (b BatchStatus) MarshalJSON() ([]byte, error) {
+	msg := batchStatusJSON{SubjectRequestId: b.SubjectRequestId, Status: b.Status}
+	if b.Error != nil {
+		msg.Error = &errMsgInner{Code: b.Error.Code, Message: b.Error.Message, Errors: b.Error.Errors}
+	}
+	return json.Marshal(msg)
+}
+
+func (b *BatchStatus) UnmarshalJSON(raw []byte) error {
+	msg := batchStatusJSON{}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return err
+	}
+	b.SubjectRequestId, b.Status, b.Error = msg.SubjectRequestId, msg.Status, nil
+	if msg.Error != nil {
+		b.Error = &ErrorResponse{Code: msg.Error.Code, Message: msg.Error.Message, Errors: msg.Error.Errors}
+	}
+	return nil
+}
+
+// BatchStatusResponse contains the status of
+// each request in the order it was queried.
+type BatchStatusResponse struct {
+	Statuses []*BatchStatus `json:"statuses"`
+}
+
+// BatchStatuser is an optional interface a Processor may
+// implement to look up the status of many requests at
+// once. Requests missing from the returned map are
+// reported as not found. Processors which do not
+// implement it have Status called for each request.
+type BatchStatuser interface {
+	StatusMany(ids []string) (map[string]*StatusResponse, error)
+}
+
+// errorResponse converts any error into an
+// ErrorResponse as it would be served.
+func errorResponse(err error) *ErrorResponse {
+	switch e := err.(type) {
+	case ErrorResponse:
+		return &e
+	case *ErrorResponse:
+		return e
+	}
+	return &ErrorResponse{Code: http.StatusInternalServerError, Message: err.Error()}
+}
+
+// batchStatus looks up the status of each request with
+// the Processor, running the status Hooks for each. Failing
+// to find one request does not fail the batch. Requests
+// submitted by a controller other than the one calling
+// are not found.
+func batchStatus(proc Processor, hooks *Hooks, controller string, ids []string) (*BatchStatusResponse, error) {
+	if len(ids) == 0 {
+		return nil, ErrMissingRequiredField("subject_request_ids")
+	}
+	if len(ids) > MaxBatchSize {
+		return nil, ErrBatchTooLarge(len(ids))
+	}
+	// Requests rejected by BeforeStatus
+	rejected := map[string]error{}
+	var allowed []string
+	for _, id := range ids {
+		if err := hooks.beforeStatus(id); err != nil {
+			rejected[id] = err
+			continue
+		}
+		allowed = append(allowed, id)
+	}
+	var statuses map[string]*StatusResponse
+	if batcher, ok := proc.(BatchStatuser); ok && len(allowed) > 0 {
+		var err error
+		statuses, err = batcher.StatusMany(allowed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp := &BatchStatusResponse{Statuses: make([]*BatchStatus, 0, len(ids))}
+	for _, id := range ids {
+		result := &BatchStatus{SubjectRequestId: id}
+		if err, ok := rejected[id]; ok {
+			result.Error = errorResponse(err)
+			resp.Statuses = append(resp.Statuses, result)
+			continue
+		}
+		var (
+			status *StatusResponse
+			err    error
+		)
+		if statuses != nil {
+			status = statuses[id]
+			if status == nil || !ownedBy(status, controller) {
+				status, err = nil, ErrNotFound(id)
+			}
+		} else {
+			status, err = ownedStatus(proc, controller, id)
+		}
+		if err = hooks.afterStatus(id, status, err); err != nil {
+			result.Error = errorResponse(err)
+		} else {
+			result.Status = status
+		}
+		resp.Statuses = append(resp.Statuses, result)
+	}
+	return resp, nil
+}
+
+// batchUnsupported reports whether the error indicates
+// the remote server does not serve batch queries, i.e.
+// the route is missing. Errors with a spec ErrorResponse
+// body were returned by a server which serves the route.
+func batchUnsupported(err error) bool {
+	errResp, ok := err.(*ErrorResponse)
+	if !ok || errResp.remote {
+		return false
+	}
+	switch errResp.Code {
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	}
+	return false
+}
+
+// StatusMany queries the status of many requests returning
+// them in the order given. Requests which could not be found
+// or otherwise failed are reported with an Error rather than
+// failing the batch. Processors which do not serve batch
+// queries are queried for each request in parallel.
+func (c *Client) StatusMany(ctx context.Context, ids []string) ([]*BatchStatus, error) {
+	var statuses []*BatchStatus
+	for start := 0; start < len(ids); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch, err := c.statusBatch(ctx, ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, batch...)
+	}
+	return statuses, nil
+}
+
+func (c *Client) statusBatch(ctx context.Context, ids []string) ([]*BatchStatus, error) {
+	c.mu.Lock()
+	noBatch := c.noBatch
+	c.mu.Unlock()
+	if !noBatch {
+		raw, err := json.Marshal(&BatchStatusRequest{SubjectRequestIds: ids})
+		if err != nil {
+			return nil, err
+		}
+		batchResp := &BatchStatusResponse{}
+		err = c.do(ctx, "POST", "/opengdpr_statuses", raw, true, true, batchResp)
+		if err == nil {
+			return batchResp.Statuses, nil
+		}
+		if !batchUnsupported(err) {
+			return nil, err
+		}
+		// Remember the processor does not
+		// serve batch queries.
+		c.mu.Lock()
+		c.noBatch = true
+		c.mu.Unlock()
+	}
+	return c.statusEach(ctx, ids)
+}
+
+// statusEach queries the status of each request
+// in parallel. Errors reported by the processor are
+// recorded against the request while any other
+// error fails the batch.
+func (c *Client) statusEach(ctx context.Context, ids []string) ([]*BatchStatus, error) {
+	statuses := make([]*BatchStatus, len(ids))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, DefaultStatusConcurrency)
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result := &BatchStatus{SubjectRequestId: id}
+			status, err := c.Status(ctx, id)
+			switch e := err.(type) {
+			case nil:
+				result.Status = status
+			case *ErrorResponse:
+				result.Error = e
+			default:
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+			statuses[i] = result
+		}(i, id)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return statuses, nil
+}
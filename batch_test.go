@@ -0,0 +1,131 @@
+package gdpr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapProcessor struct {
+	mockProcessor
+	statuses map[string]*StatusResponse
+	calls    int32
+}
+
+func (m *mapProcessor) Status(id string) (*StatusResponse, error) {
+	atomic.AddInt32(&m.calls, 1)
+	status, ok := m.statuses[id]
+	if !ok {
+		return nil, ErrNotFound(id)
+	}
+	return status, nil
+}
+
+type batchProcessor struct {
+	*mapProcessor
+}
+
+func (b batchProcessor) StatusMany(ids []string) (map[string]*StatusResponse, error) {
+	return b.statuses, nil
+}
+
+func newMapProcessor() *mapProcessor {
+	return &mapProcessor{statuses: map[string]*StatusResponse{
+		"1234": &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_PENDING},
+		"4321": &StatusResponse{SubjectRequestId: "4321", RequestStatus: STATUS_COMPLETED},
+	}}
+}
+
+func checkStatuses(t *testing.T, statuses []*BatchStatus) {
+	assert.Len(t, statuses, 3)
+	assert.Equal(t, "4321", statuses[0].SubjectRequestId)
+	assert.Equal(t, STATUS_COMPLETED, statuses[0].Status.RequestStatus)
+	assert.Equal(t, "missing", statuses[1].SubjectRequestId)
+	assert.Nil(t, statuses[1].Status)
+	assert.Equal(t, 404, statuses[1].Error.Code)
+	assert.Equal(t, STATUS_PENDING, statuses[2].Status.RequestStatus)
+}
+
+func TestClientStatusMany(t *testing.T) {
+	ids := []string{"4321", "missing", "1234"}
+	for _, proc := range []Processor{newMapProcessor(), batchProcessor{newMapProcessor()}} {
+		svr := httptest.NewServer(NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: proc}))
+		client := NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}})
+		statuses, err := client.StatusMany(context.Background(), ids)
+		assert.NoError(t, err)
+		checkStatuses(t, statuses)
+		svr.Close()
+	}
+	// Too many or too few requests
+	svr := httptest.NewServer(NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: newMapProcessor()}))
+	defer svr.Close()
+	client := NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}})
+	err := client.do(context.Background(), "POST", "/opengdpr_statuses", []byte(`{"subject_request_ids":[]}`), true, true, &BatchStatusResponse{})
+	assert.Equal(t, 400, err.(*ErrorResponse).Code)
+	_, err = batchStatus(newMapProcessor(), nil, "", make([]string, MaxBatchSize+1))
+	assert.Error(t, err)
+}
+
+func TestClientStatusManyFallback(t *testing.T) {
+	proc := newMapProcessor()
+	svr := httptest.NewServer(NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: proc}))
+	defer svr.Close()
+	// Processor which does not serve batch queries
+	batchCalls := 0
+	client := NewClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/opengdpr_statuses" {
+				batchCalls++
+				return newResponse(404, []byte("404 page not found")), nil
+			}
+			return http.DefaultClient.Do(r)
+		}),
+	})
+	ids := []string{"4321", "missing", "1234"}
+	for i := 0; i < 2; i++ {
+		statuses, err := client.StatusMany(context.Background(), ids)
+		assert.NoError(t, err)
+		checkStatuses(t, statuses)
+	}
+	assert.Equal(t, 1, batchCalls)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&proc.calls))
+}
+
+func TestClientStatusManyNoFallback(t *testing.T) {
+	proc := newMapProcessor()
+	svr := httptest.NewServer(NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: proc}))
+	defer svr.Close()
+	// Errors returned by a server serving the route
+	client := NewClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/opengdpr_statuses" {
+				raw, _ := json.Marshal(ErrNotFound("4321"))
+				return newResponse(404, raw), nil
+			}
+			return http.DefaultClient.Do(r)
+		}),
+	})
+	_, err := client.StatusMany(context.Background(), []string{"4321"})
+	assert.Equal(t, 404, err.(*ErrorResponse).Code)
+	assert.False(t, client.noBatch)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&proc.calls))
+}
+
+func TestBatchStatusJSON(t *testing.T) {
+	raw, err := json.Marshal(&BatchStatus{SubjectRequestId: "1234", Error: &ErrorResponse{Code: 404, Message: "not found"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"subject_request_id":"1234","error":{"code":404,"message":"not found","errors":null}}`, string(raw))
+	status := &BatchStatus{}
+	assert.NoError(t, json.Unmarshal(raw, status))
+	assert.Equal(t, 404, status.Error.Code)
+	assert.Equal(t, "not found", status.Error.Message)
+}
@@ -2,6 +2,7 @@ package gdpr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -15,36 +16,101 @@ type CallbackOptions struct {
 	ProcessorDomain string
 	Client          *http.Client
 	Signer          Signer
+	// Optional replay protection, when set a signed
+	// timestamp and nonce are sent with the callback.
+	Replay *ReplayOptions
+	// Optional Metrics recorded for each callback.
+	Metrics Metrics
+	// Optional Tracer notified of the
+	// span sending each callback.
+	Tracer Tracer
+	// Optional Logger of structured events.
+	Logger Logger
 }
 
 // Callback sends the CallbackRequest type to the configured
 // StatusCallbackUrl. If it fails to deliver in n attempts or
 // the request is invalid it will return an error.
 func Callback(cbReq *CallbackRequest, opts *CallbackOptions) error {
+	return CallbackContext(context.Background(), cbReq, opts)
+}
+
+// CallbackContext is like Callback but stops sending the
+// callback once the context is done, returning it's error.
+func CallbackContext(ctx context.Context, cbReq *CallbackRequest, opts *CallbackOptions) (err error) {
 	client := opts.Client
 	if client == nil {
 		client = http.DefaultClient
 	}
-	buf := bytes.NewBuffer(nil)
-	err := json.NewEncoder(buf).Encode(cbReq)
-	if err != nil {
-		return err
+	var parent *TraceContext
+	if trace, err := ParseTraceParent(cbReq.TraceParent); err == nil {
+		parent = &trace
 	}
-	signature, err := opts.Signer.Sign(buf.Bytes())
+	span := startSpan(opts.Tracer, "callback", parent)
+	span.Attributes["gdpr.subject_request_id"] = cbReq.SubjectRequestId
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		incCounter(opts.Metrics, MetricCallbacks, map[string]string{"result": result})
+		endSpan(opts.Tracer, span, err)
+	}()
+	buf := bytes.NewBuffer(nil)
+	err = json.NewEncoder(buf).Encode(cbReq)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", cbReq.StatusCallbackUrl, buf)
-	if err != nil {
-		return err
+	header := http.Header{}
+	// With replay protection each attempt is signed with a
+	// fresh nonce so a retry of a delivered callback whose
+	// response was lost is not rejected as a replay.
+	replay := newReplayOptions(opts.Replay)
+	if replay == nil {
+		signature, err := opts.Signer.Sign(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		header.Set("X-OpenGDPR-Signature", signature)
 	}
-	req.Header.Set("X-OpenGDPR-Processor-Domain", opts.ProcessorDomain)
-	req.Header.Set("X-OpenGDPR-Signature", signature)
+	header.Set(ProcessorDomainHeader, opts.ProcessorDomain)
+	header.Set("GDPR-Version", ApiVersion)
+	header.Set(TraceParentHeader, span.Context().String())
+	start := time.Now()
+	defer observeDuration(opts.Metrics, MetricCallbackDuration, nil, start)
 	// Attempt to make callback
 	for i := 0; i < opts.MaxAttempts; i++ {
+		// Each attempt needs it's own request
+		// since the body is consumed when sent.
+		req, err := http.NewRequest("POST", cbReq.StatusCallbackUrl, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header = header.Clone()
+		if replay != nil {
+			if _, err := replay.sign(opts.Signer, req.Header, buf.Bytes()); err != nil {
+				return err
+			}
+		}
 		resp, err := client.Do(req)
+		fields := map[string]interface{}{
+			"subject_request_id": cbReq.SubjectRequestId,
+			"processor_domain":   opts.ProcessorDomain,
+			"url":                cbReq.StatusCallbackUrl,
+			"attempt":            i + 1,
+		}
+		if err == nil {
+			resp.Body.Close()
+			fields["status_code"] = resp.StatusCode
+		} else {
+			fields["error"] = err
+		}
+		logEvent(opts.Logger, EventCallbackAttempt, fields)
 		if err != nil || resp.StatusCode != 200 {
-			time.Sleep(opts.Backoff)
+			if err := sleepContext(ctx, opts.Backoff); err != nil {
+				return err
+			}
 			continue
 		}
 		// Success
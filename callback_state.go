@@ -0,0 +1,100 @@
+package gdpr
+
+import "sync"
+
+// CallbackStates records the last status delivered to a
+// Controller for each request so that retried and out of
+// order callbacks can be ignored.
+type CallbackStates interface {
+	// Advance records the status of the request sent by
+	// the processor with the given domain and reports
+	// whether it moved the request forward along with the
+	// status it replaced, which is empty if there was
+	// none. Duplicate or stale statuses are not recorded.
+	Advance(domain, id string, status RequestStatus) (RequestStatus, bool, error)
+	// Revert undoes an Advance to status which could not
+	// be delivered so the processor's retry is accepted,
+	// restoring previous unless the request has since
+	// moved on to another status.
+	Revert(domain, id string, status, previous RequestStatus) error
+}
+
+// MaxFinishedCallbacks is the number of finished
+// requests remembered by the memory CallbackStates so
+// that duplicates of their final callback are ignored.
+const MaxFinishedCallbacks = 1024
+
+// NewMemoryCallbackStates returns CallbackStates
+// which are kept in memory. Requests are removed
+// once they are finished, only the most recent
+// MaxFinishedCallbacks of them are remembered.
+func NewMemoryCallbackStates() CallbackStates {
+	return &memoryCallbackStates{
+		states:   map[callbackKey]RequestStatus{},
+		finished: map[callbackKey]RequestStatus{},
+	}
+}
+
+type callbackKey struct {
+	domain string
+	id     string
+}
+
+type memoryCallbackStates struct {
+	mu     sync.Mutex
+	states map[callbackKey]RequestStatus
+	// finished requests, oldest first in order
+	finished map[callbackKey]RequestStatus
+	order    []callbackKey
+}
+
+func (m *memoryCallbackStates) Advance(domain, id string, status RequestStatus) (RequestStatus, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := callbackKey{domain: domain, id: id}
+	if final, ok := m.finished[key]; ok {
+		return final, false, nil
+	}
+	previous, ok := m.states[key]
+	if ok && !previous.Precedes(status) {
+		return previous, false, nil
+	}
+	if !status.Finished() {
+		m.states[key] = status
+		return previous, true, nil
+	}
+	delete(m.states, key)
+	m.finished[key] = status
+	m.order = append(m.order, key)
+	if len(m.order) > MaxFinishedCallbacks {
+		delete(m.finished, m.order[0])
+		m.order = m.order[1:]
+	}
+	return previous, true, nil
+}
+
+func (m *memoryCallbackStates) Revert(domain, id string, status, previous RequestStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := callbackKey{domain: domain, id: id}
+	if final, ok := m.finished[key]; ok {
+		if final != status {
+			return nil
+		}
+		delete(m.finished, key)
+		for i, k := range m.order {
+			if k == key {
+				m.order = append(m.order[:i], m.order[i+1:]...)
+				break
+			}
+		}
+	} else if m.states[key] != status {
+		return nil
+	}
+	if previous == "" {
+		delete(m.states, key)
+	} else {
+		m.states[key] = previous
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package gdpr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestStatusPrecedes(t *testing.T) {
+	assert.True(t, STATUS_PENDING.Precedes(STATUS_IN_PROGRESS))
+	assert.True(t, STATUS_IN_PROGRESS.Precedes(STATUS_COMPLETED))
+	assert.True(t, STATUS_PENDING.Precedes(STATUS_CANCELLED))
+	assert.False(t, STATUS_IN_PROGRESS.Precedes(STATUS_IN_PROGRESS))
+	assert.False(t, STATUS_COMPLETED.Precedes(STATUS_IN_PROGRESS))
+	assert.False(t, STATUS_COMPLETED.Precedes(STATUS_CANCELLED))
+}
+
+func TestServerCallbackStates(t *testing.T) {
+	controller := &mockController{}
+	server := NewServer(&ServerOptions{
+		Controller:     controller,
+		Verifier:       NoopVerifier{},
+		CallbackStates: NewMemoryCallbackStates(),
+	})
+	callback := func(domain string, status RequestStatus) int {
+		body, _ := json.Marshal(&CallbackRequest{SubjectRequestId: "1234", RequestStatus: status})
+		r := httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+		r.Header.Set(ProcessorDomainHeader, domain)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, 200, callback("one.com", STATUS_IN_PROGRESS))
+	// Duplicate
+	assert.Equal(t, 200, callback("one.com", STATUS_IN_PROGRESS))
+	assert.Equal(t, 200, callback("one.com", STATUS_COMPLETED))
+	// Delivered out of order
+	assert.Equal(t, 200, callback("one.com", STATUS_PENDING))
+	assert.Equal(t, 200, callback("one.com", STATUS_IN_PROGRESS))
+	// Tracked separately for each processor
+	assert.Equal(t, 200, callback("two.com", STATUS_IN_PROGRESS))
+	assert.Len(t, controller.callbacks, 3)
+	assert.Equal(t, STATUS_IN_PROGRESS, controller.callbacks[0].RequestStatus)
+	assert.Equal(t, STATUS_COMPLETED, controller.callbacks[1].RequestStatus)
+	assert.Equal(t, STATUS_IN_PROGRESS, controller.callbacks[2].RequestStatus)
+}
+
+func TestServerCallbackRetry(t *testing.T) {
+	ledger := NewMemoryLedger()
+	assert.NoError(t, ledger.Put(&LedgerEntry{
+		ProcessorDomain:  "two.com",
+		SubjectRequestId: "1234",
+		RequestStatus:    STATUS_PENDING,
+	}))
+	for _, opts := range []*ServerOptions{
+		{CallbackStates: NewMemoryCallbackStates()},
+		{Ledger: ledger},
+	} {
+		controller := &mockController{failures: 1}
+		opts.Controller = controller
+		opts.Verifier = NoopVerifier{}
+		server := NewServer(opts)
+		callback := func(domain string, status RequestStatus) int {
+			body, _ := json.Marshal(&CallbackRequest{SubjectRequestId: "1234", RequestStatus: status})
+			r := httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+			r.Header.Set(ProcessorDomainHeader, domain)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+			return w.Code
+		}
+		// The status is not recorded when the controller fails
+		assert.Equal(t, 500, callback("two.com", STATUS_COMPLETED))
+		assert.Len(t, controller.callbacks, 0)
+		// So the processor's retry is delivered
+		assert.Equal(t, 200, callback("two.com", STATUS_COMPLETED))
+		assert.Len(t, controller.callbacks, 1)
+		assert.Equal(t, 200, callback("two.com", STATUS_COMPLETED))
+		assert.Len(t, controller.callbacks, 1)
+	}
+	entry, err := ledger.Get("two.com", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, entry.RequestStatus)
+}
+
+func TestMemoryCallbackStatesFinished(t *testing.T) {
+	states := NewMemoryCallbackStates().(*memoryCallbackStates)
+	_, advanced, _ := states.Advance("one.com", "a/b", STATUS_IN_PROGRESS)
+	assert.True(t, advanced)
+	// Keys are not ambiguous
+	_, advanced, _ = states.Advance("one.com/a", "b", STATUS_IN_PROGRESS)
+	assert.True(t, advanced)
+	previous, advanced, _ := states.Advance("one.com", "a/b", STATUS_COMPLETED)
+	assert.True(t, advanced)
+	assert.Equal(t, STATUS_IN_PROGRESS, previous)
+	// Finished requests are removed but duplicates are ignored
+	assert.Len(t, states.states, 1)
+	_, advanced, _ = states.Advance("one.com", "a/b", STATUS_COMPLETED)
+	assert.False(t, advanced)
+	for i := 0; i < MaxFinishedCallbacks; i++ {
+		states.Advance("one.com", strconv.Itoa(i), STATUS_CANCELLED)
+	}
+	assert.Len(t, states.finished, MaxFinishedCallbacks)
+	assert.Len(t, states.order, MaxFinishedCallbacks)
+	_, ok := states.finished[callbackKey{domain: "one.com", id: "a/b"}]
+	assert.False(t, ok)
+}
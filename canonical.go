@@ -0,0 +1,265 @@
+package gdpr
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Canonicalize re-encodes a JSON document following the
+// JSON Canonicalization Scheme (RFC 8785) so that signatures
+// do not depend on how a peer or proxy chose to serialize
+// the payload. Object keys are sorted by their UTF-16 code
+// units, insignificant whitespace is removed and numbers
+// and strings are serialized as by ECMAScript. Duplicate
+// keys and trailing data are rejected.
+func Canonicalize(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	buf := bytes.NewBuffer(nil)
+	if err := canonicalValue(decoder, buf); err != nil {
+		return nil, err
+	}
+	// Reject any trailing data after the document
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrInvalidJSON("unexpected data after top-level value")
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalValue writes the canonical form of
+// the next value read from decoder to buf.
+func canonicalValue(decoder *json.Decoder, buf *bytes.Buffer) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '[' {
+			return canonicalArray(decoder, buf)
+		}
+		if value == '{' {
+			return canonicalObject(decoder, buf)
+		}
+		return ErrInvalidJSON(fmt.Sprintf("unexpected %s", value))
+	case string:
+		canonicalString(value, buf)
+	case json.Number:
+		f, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return ErrInvalidJSON(fmt.Sprintf("number out of range: %s", value))
+		}
+		buf.WriteString(canonicalNumber(f))
+	case bool:
+		buf.WriteString(strconv.FormatBool(value))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func canonicalArray(decoder *json.Decoder, buf *bytes.Buffer) error {
+	buf.WriteByte('[')
+	for i := 0; decoder.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := canonicalValue(decoder, buf); err != nil {
+			return err
+		}
+	}
+	// Consume the closing delimiter
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func canonicalObject(decoder *json.Decoder, buf *bytes.Buffer) error {
+	members := map[string][]byte{}
+	keys := []string{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := token.(string)
+		if _, ok := members[key]; ok {
+			return ErrInvalidJSON(fmt.Sprintf("duplicate key: %s", key))
+		}
+		value := bytes.NewBuffer(nil)
+		if err := canonicalValue(decoder, value); err != nil {
+			return err
+		}
+		members[key] = value.Bytes()
+		keys = append(keys, key)
+	}
+	// Consume the closing delimiter
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		canonicalString(key, buf)
+		buf.WriteByte(':')
+		buf.Write(members[key])
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// lessUTF16 compares strings by their UTF-16 code units.
+func lessUTF16(a, b string) bool {
+	x, y := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return x[i] < y[i]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// canonicalString writes a JSON string escaping only
+// quotation marks, reverse solidus and control characters.
+func canonicalString(s string, buf *bytes.Buffer) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber serializes a number as by
+// ECMAScript's Number.prototype.toString.
+func canonicalNumber(f float64) string {
+	if f == 0 {
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign, f = "-", -f
+	}
+	// The shortest digits which round trip
+	// and the exponent of the first digit.
+	formatted := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp := formatted, 0
+	if i := strings.IndexByte(formatted, 'e'); i >= 0 {
+		mantissa = formatted[:i]
+		exp, _ = strconv.Atoi(formatted[i+1:])
+	}
+	digits := strings.Replace(mantissa, ".", "", 1)
+	k, n := len(digits), exp+1
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits
+	}
+	result := sign + digits[:1]
+	if k > 1 {
+		result += "." + digits[1:]
+	}
+	if n-1 >= 0 {
+		return result + "e+" + strconv.Itoa(n-1)
+	}
+	return result + "e" + strconv.Itoa(n-1)
+}
+
+// CanonicalSigner wraps a Signer so that it signs the
+// canonical form of each JSON payload.
+func CanonicalSigner(signer Signer) Signer {
+	return &canonicalSigner{signer: signer}
+}
+
+// CanonicalVerifier wraps a Verifier so that it
+// verifies signatures against the canonical form
+// of each JSON payload.
+func CanonicalVerifier(verifier Verifier) Verifier {
+	return &canonicalVerifier{verifier: verifier}
+}
+
+type canonicalSigner struct {
+	signer Signer
+}
+
+func (s *canonicalSigner) Sign(body []byte) (string, error) {
+	canonical, err := Canonicalize(body)
+	if err != nil {
+		return "", err
+	}
+	return s.signer.Sign(canonical)
+}
+
+type canonicalVerifier struct {
+	verifier Verifier
+}
+
+func (v *canonicalVerifier) Verify(body []byte, signature string) error {
+	canonical, err := Canonicalize(body)
+	if err != nil {
+		return ErrInvalidRequestSignature(signature, err)
+	}
+	return v.verifier.Verify(canonical, signature)
+}
+
+func (v *canonicalVerifier) Cert() *x509.Certificate {
+	return v.verifier.Cert()
+}
+
+// canonicalSignerPayload unwraps a canonical Signer
+// returning the underlying Signer and the canonical
+// form of body. Used when the signed payload is not
+// only the JSON body.
+func canonicalSignerPayload(signer Signer, body []byte) (Signer, []byte, error) {
+	if s, ok := signer.(*canonicalSigner); ok {
+		canonical, err := Canonicalize(body)
+		return s.signer, canonical, err
+	}
+	return signer, body, nil
+}
+
+// canonicalVerifierPayload unwraps a canonical Verifier
+// returning the underlying Verifier and the canonical
+// form of body.
+func canonicalVerifierPayload(verifier Verifier, body []byte) (Verifier, []byte, error) {
+	if v, ok := verifier.(*canonicalVerifier); ok {
+		canonical, err := Canonicalize(body)
+		return v.verifier, canonical, err
+	}
+	return verifier, body, nil
+}
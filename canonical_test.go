@@ -0,0 +1,109 @@
+package gdpr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Examples from sections 3.2.2 and 3.2.3 of RFC 8785.
+var canonicalVectors = []struct {
+	input    string
+	expected string
+}{
+	{
+		`{
+  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`,
+		`{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+	},
+	{
+		`{
+  "\u20ac": "Euro Sign",
+  "\r": "Carriage Return",
+  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+  "1": "One",
+  "\ud83d\ude00": "Emoji: Grinning Face",
+  "\u0080": "Control",
+  "\u00f6": "Latin Small Letter O With Diaeresis"
+}`,
+		"{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\"," +
+			"\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+	},
+	{`{"url": "https://example.com/?a=1&b=<2>"}`, `{"url":"https://example.com/?a=1&b=<2>"}`},
+}
+
+// IEEE-754 values and their serialization from
+// appendix B of RFC 8785.
+var canonicalNumberVectors = []struct {
+	bits     uint64
+	expected string
+}{
+	{0x0000000000000000, "0"},
+	{0x8000000000000000, "0"},
+	{0x0000000000000001, "5e-324"},
+	{0x8000000000000001, "-5e-324"},
+	{0x7fefffffffffffff, "1.7976931348623157e+308"},
+	{0xffefffffffffffff, "-1.7976931348623157e+308"},
+	{0x4340000000000000, "9007199254740992"},
+	{0xc340000000000000, "-9007199254740992"},
+	{0x4430000000000000, "295147905179352830000"},
+	{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+	{0x44b52d02c7e14af6, "1e+23"},
+	{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+	{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+	{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+	{0x444b1ae4d6e2ef50, "1e+21"},
+	{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+	{0x3eb0c6f7a0b5ed8d, "0.000001"},
+	{0x41b3de4355555553, "333333333.3333332"},
+	{0x41b3de4355555554, "333333333.33333325"},
+	{0x41b3de4355555555, "333333333.3333333"},
+	{0x41b3de4355555556, "333333333.3333334"},
+	{0x41b3de4355555557, "333333333.33333343"},
+	{0xbecbf647612f3696, "-0.0000033333333333333333"},
+	{0x43143ff3c1cb0959, "1424953923781206.2"},
+}
+
+// Serializations of the same document which
+// share a single canonical form.
+var canonicalSignatureInputs = []string{
+	`{"controller_id":"example_controller_id","expected_completion_time":"2018-11-01T15:00:01Z","n":1.50,"results_url":"https://example.com/?a=1&b=<2>","subject_request_id":"a7551968"}`,
+	`{"subject_request_id": "a7551968", "controller_id": "example_controller_id", "results_url": "https://example.com/?a=1\u0026b=\u003c2\u003e", "expected_completion_time": "2018-11-01T15:00:01Z", "n": 15e-1}` + "\n",
+	"{\n\t\"n\": 1.5,\n\t\"results_url\": \"https://example.com/?a=1&b=<2>\",\n\t\"expected_completion_time\": \"2018-11-01T15:00:01Z\",\n\t\"controller_id\": \"example_controller_id\",\n\t\"subject_request_id\": \"a7551968\"\n}",
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, vector := range canonicalVectors {
+		canonical, err := Canonicalize([]byte(vector.input))
+		assert.NoError(t, err)
+		assert.Equal(t, vector.expected, string(canonical))
+	}
+	for _, vector := range canonicalNumberVectors {
+		assert.Equal(t, vector.expected, canonicalNumber(math.Float64frombits(vector.bits)))
+	}
+	for _, invalid := range []string{`{"a":1} {"b":2}`, `{"a":1} }`, `{"a":1}]`, `{"a":`, `{"a":1,"a":2}`, `[1e400]`} {
+		_, err := Canonicalize([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCanonicalSignerVerifier(t *testing.T) {
+	signer := MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0], Canonical: true})
+	verifier := MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1], Canonical: true})
+	sig, err := signer.Sign([]byte(canonicalSignatureInputs[0]))
+	assert.NoError(t, err)
+	for _, input := range canonicalSignatureInputs {
+		assert.NoError(t, verifier.Verify([]byte(input), sig))
+	}
+	// A non-canonical verifier only accepts the canonical bytes
+	canonical, err := Canonicalize([]byte(canonicalSignatureInputs[0]))
+	assert.NoError(t, err)
+	plain := MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]})
+	assert.NoError(t, plain.Verify(canonical, sig))
+	assert.Error(t, plain.Verify([]byte(canonicalSignatureInputs[1]), sig))
+	assert.Error(t, verifier.Verify([]byte(`{"controller_id":"other"}`), sig))
+}
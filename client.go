@@ -2,128 +2,434 @@ package gdpr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
-type caller interface {
-	Call(string, string, io.Reader) (*http.Response, error)
+// Caller performs the HTTP requests made by a Client.
+// Implementations may wrap an http.Client to add
+// authentication, tracing or other behaviour.
+type Caller interface {
+	Call(req *http.Request) (*http.Response, error)
 }
 
-type defaultCaller struct {
-	client  *http.Client
-	headers map[string]string
-}
+// CallerFunc adapts an ordinary function to a Caller.
+type CallerFunc func(req *http.Request) (*http.Response, error)
 
-func (d defaultCaller) Call(method, url string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
-	}
-	for key, value := range d.headers {
-		req.Header.Set(key, value)
-	}
-	return d.client.Do(req)
-}
+func (fn CallerFunc) Call(req *http.Request) (*http.Response, error) { return fn(req) }
 
 // ClientOptions conifigure a Client.
 type ClientOptions struct {
 	Endpoint string
 	Verifier Verifier
 	Client   *http.Client
+	// Optional Caller used in place of Client.
+	Caller Caller
+	// Version of the specification to speak with the
+	// processor, defaults to ApiVersion. It must be
+	// ApiVersion or a version in DefaultMigrations.
+	ApiVersion string
+	// Optional replay protection used to reject
+	// stale or replayed responses.
+	Replay *ReplayOptions
+	// Optional policy for retrying transient
+	// failures, calls are not retried if nil.
+	Retry *RetryPolicy
+	// Optional limit on the duration of each
+	// call including any retries.
+	Timeout time.Duration
+	// Optional validation of requests against the
+	// processor's cached DiscoveryResponse.
+	Discovery *DiscoveryOptions
+	// Optional Ledger recording every request
+	// successfully submitted to the processor.
+	Ledger Ledger
+	// Domain of the processor, required
+	// when a Ledger is configured.
+	ProcessorDomain string
+	// Optional Signer used to sign each request
+	// for processors authenticating controllers
+	// with NewSignatureAuthenticator.
+	Signer Signer
+	// Identity of the controller sent
+	// with signed requests.
+	ControllerId string
+	// Optional Metrics recorded for each call.
+	Metrics Metrics
+	// Optional Tracer notified of the span of each call.
+	// Calls continue any trace carried by their context,
+	// see ContextWithTrace.
+	Tracer Tracer
+	// Optional Logger of structured events.
+	Logger Logger
 }
 
 // Client is an HTTP helper client for making requests
 // to an OpenGDPR processor server.
 type Client struct {
-	endpoint string
-	caller   caller
-	verifier Verifier
+	endpoint  string
+	caller    Caller
+	verifier  Verifier
+	headers   http.Header
+	replay    *ReplayOptions
+	retry     *RetryPolicy
+	timeout   time.Duration
+	discovery *discoveryCache
+	ledger    Ledger
+	domain    string
+	signer    Signer
+	// identity sent with signed requests
+	controllerId string
+	metrics      Metrics
+	tracer       Tracer
+	logger       Logger
+	// guards version and noBatch
+	mu sync.Mutex
+	// version of the specification
+	// spoken with the processor
+	version string
+	// set once the processor is known not
+	// to serve batch status queries
+	noBatch bool
 }
 
-func (c *Client) json(resp *http.Response, err error, verify bool, v interface{}) error {
-	if err != nil {
-		return err
+// apiVersion returns the version currently
+// spoken by the client.
+func (c *Client) apiVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// migration returns the Migration for the version
+// currently spoken by the client if any.
+func (c *Client) migration() Migration {
+	version := c.apiVersion()
+	if version == ApiVersion {
+		return nil
 	}
+	return DefaultMigrations[version]
+}
+
+func (c *Client) json(resp *http.Response, verify bool, v interface{}) error {
 	defer resp.Body.Close()
 	raw, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return &TransportError{StatusCode: resp.StatusCode, Err: err}
 	}
 	if resp.StatusCode >= 400 {
 		// Try to decode an internal ErrorResponse type
-		// then fall back to returning the body as a string.
-		errResp := &ErrorResponse{Code: resp.StatusCode}
-		err = json.Unmarshal(raw, errResp)
-		if err == nil {
-			return errResp
+		// then fall back to using the body as the message.
+		errResp := &ErrorResponse{}
+		if json.Unmarshal(raw, errResp) != nil {
+			errResp.Message = string(raw)
 		}
-		return fmt.Errorf("Server responded with %d: %s", resp.StatusCode, string(raw))
+		errResp.remote = errResp.Code != 0
+		if errResp.Code == 0 {
+			errResp.Code = resp.StatusCode
+		}
+		return errResp
 	}
 	if v == nil {
 		return nil
 	}
 	if verify {
 		// verify the remote signature
-		if err := c.verifier.Verify(raw, resp.Header.Get("X-OpenGDPR-Signature")); err != nil {
-			return fmt.Errorf("could not verify remote X-OpenGDPR-Signature")
+		if err := c.verify(resp.Header, raw); err != nil {
+			incCounter(c.metrics, MetricVerificationFailures, map[string]string{"side": "client"})
+			logEvent(c.logger, EventSignatureMismatch, map[string]interface{}{
+				"processor_domain": c.domain,
+				"error":            err,
+			})
+			return &TransportError{
+				StatusCode: resp.StatusCode,
+				Err:        fmt.Errorf("could not verify remote X-OpenGDPR-Signature: %w", err),
+			}
+		}
+	}
+	if migration := c.migration(); migration != nil {
+		raw, err = migration.Upgrade(raw)
+		if err != nil {
+			return &TransportError{StatusCode: resp.StatusCode, Err: err}
+		}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &TransportError{StatusCode: resp.StatusCode, Err: err}
+	}
+	return nil
+}
+
+// verify checks the signature of a response payload.
+func (c *Client) verify(header http.Header, raw []byte) error {
+	if c.replay != nil {
+		return c.replay.verify(c.verifier, header, raw)
+	}
+	return c.verifier.Verify(raw, header.Get("X-OpenGDPR-Signature"))
+}
+
+// sign adds the controller's identity and a
+// signature of the request to it's headers.
+func (c *Client) sign(req *http.Request, body []byte) error {
+	signer := c.signer
+	if len(body) > 0 {
+		var err error
+		signer, body, err = canonicalSignerPayload(signer, body)
+		if err != nil {
+			return err
+		}
+	}
+	payload := RequestSigningPayload(req.Method, req.URL.RequestURI(), body)
+	req.Header.Set(ControllerIdHeader, c.controllerId)
+	if c.replay != nil {
+		_, err := c.replay.sign(signer, req.Header, payload)
+		return err
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-OpenGDPR-Signature", signature)
+	return nil
+}
+
+// call makes a single attempt at a request to the
+// remote server decoding the response into v.
+func (c *Client) call(ctx context.Context, method, path string, body []byte, verify bool, v interface{}) (err error) {
+	// Propagate the trace of the call
+	if trace, traced := TraceFromContext(ctx); traced || c.tracer != nil {
+		var parent *TraceContext
+		if traced {
+			parent = &trace
+		}
+		span := startSpan(c.tracer, method+" "+clientRoute(path), parent)
+		defer func() { endSpan(c.tracer, span, err) }()
+		ctx = ContextWithTrace(ctx, span.Context())
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range c.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("GDPR-Version", c.apiVersion())
+	if trace, ok := TraceFromContext(ctx); ok {
+		req.Header.Set(TraceParentHeader, trace.String())
+	}
+	if c.signer != nil {
+		if err := c.sign(req, body); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	resp, err := c.caller.Call(req)
+	labels := map[string]string{"route": clientRoute(path), "method": method}
+	observeDuration(c.metrics, MetricClientDuration, labels, start)
+	if err != nil {
+		labels["code"] = "error"
+		incCounter(c.metrics, MetricClientRequests, labels)
+		return &TransportError{Method: method, URL: req.URL.String(), Err: err}
+	}
+	labels["code"] = strconv.Itoa(resp.StatusCode)
+	incCounter(c.metrics, MetricClientRequests, labels)
+	err = c.json(resp, verify, v)
+	if e, ok := err.(*TransportError); ok {
+		e.Method, e.URL = method, req.URL.String()
+	}
+	return err
+}
+
+// do performs a call against the remote server, transient
+// failures are retried according to the RetryPolicy if
+// the call is idempotent.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, verify, idempotent bool, v interface{}) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	attempts := 1
+	if idempotent && c.retry != nil && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			if err := sleepContext(ctx, c.retry.backoff(i)); err != nil {
+				return &TransportError{Method: method, URL: c.endpoint + path, Err: err}
+			}
+		}
+		err = c.call(ctx, method, path, body, verify, v)
+		if !Retryable(err) {
+			return err
 		}
 	}
-	return json.Unmarshal(raw, v)
+	return err
 }
 
-// Request makes a performs a new GDPR request.
-func (c *Client) Request(req *Request) (*Response, error) {
-	buf := bytes.NewBuffer(nil)
-	err := json.NewEncoder(buf).Encode(req)
+// Request makes a performs a new GDPR request. Requests are
+// only retried if RetryPolicy.RetryRequests is set.
+func (c *Client) Request(ctx context.Context, req *Request) (*Response, error) {
+	req, err := c.Prepare(ctx, req)
 	if err != nil {
 		return nil, err
 	}
+	if req.ApiVersion == "" {
+		req.ApiVersion = ApiVersion
+	}
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if migration := c.migration(); migration != nil {
+		raw, err = migration.Downgrade(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	idempotent := c.retry != nil && c.retry.RetryRequests
 	reqResp := &Response{}
-	resp, err := c.caller.Call("POST", c.endpoint+"/opengdpr_requests", buf)
-	return reqResp, c.json(resp, err, true, reqResp)
+	err = c.do(ctx, "POST", "/opengdpr_requests", raw, true, idempotent, reqResp)
+	fields := map[string]interface{}{
+		"subject_request_id":   req.SubjectRequestId,
+		"subject_request_type": req.SubjectRequestType,
+		"subject_identities":   req.SubjectIdentities,
+		"processor_domain":     c.domain,
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	logEvent(c.logger, EventRequestSent, fields)
+	if err != nil {
+		return nil, err
+	}
+	if c.ledger != nil {
+		err = c.ledger.Put(&LedgerEntry{
+			ProcessorDomain:        c.domain,
+			SubjectRequestId:       req.SubjectRequestId,
+			Request:                LedgerRequest(req),
+			Response:               reqResp,
+			RequestStatus:          STATUS_PENDING,
+			ExpectedCompletionTime: reqResp.ExpectedCompletionTime,
+			UpdatedTime:            time.Now(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("request %s was accepted but not recorded in the ledger: %w", req.SubjectRequestId, err)
+		}
+	}
+	return reqResp, nil
 }
 
 // Status checks the status of an existing GDPR request.
-func (c *Client) Status(id string) (*StatusResponse, error) {
+func (c *Client) Status(ctx context.Context, id string) (*StatusResponse, error) {
 	statResp := &StatusResponse{}
-	resp, err := c.caller.Call("GET", c.endpoint+"/opengdpr_requests/"+id, nil)
-	return statResp, c.json(resp, err, true, statResp)
+	return statResp, c.do(ctx, "GET", "/opengdpr_requests/"+id, nil, true, true, statResp)
+}
+
+// List searches the requests held by the processor, see
+// Lister. The Caller must add whatever credentials the
+// processor's Authenticator expects.
+func (c *Client) List(ctx context.Context, opts *ListOptions) (*ListResponse, error) {
+	path := "/opengdpr_requests"
+	if opts != nil {
+		if query := opts.Values().Encode(); query != "" {
+			path += "?" + query
+		}
+	}
+	listResp := &ListResponse{}
+	return listResp, c.do(ctx, "GET", path, nil, true, true, listResp)
 }
 
 // Cancel cancels an existing GDPR request.
-func (c *Client) Cancel(id string) (*CancellationResponse, error) {
+func (c *Client) Cancel(ctx context.Context, id string) (*CancellationResponse, error) {
 	cancelResp := &CancellationResponse{}
-	resp, err := c.caller.Call("DELETE", c.endpoint+"/opengdpr_requests/"+id, nil)
-	return cancelResp, c.json(resp, err, true, cancelResp)
+	return cancelResp, c.do(ctx, "DELETE", "/opengdpr_requests/"+id, nil, true, false, cancelResp)
 }
 
 // Discovery describes the remote OpenGDPR speciication.
-func (c *Client) Discovery() (*DiscoveryResponse, error) {
+func (c *Client) Discovery(ctx context.Context) (*DiscoveryResponse, error) {
 	discResp := &DiscoveryResponse{}
-	resp, err := c.caller.Call("GET", c.endpoint+"/discovery", nil)
-	return discResp, c.json(resp, err, false, discResp)
+	return discResp, c.do(ctx, "GET", "/discovery", nil, false, true, discResp)
+}
+
+// Negotiate selects the newest version of the
+// specification supported by both the client and
+// the remote processor and uses it for all
+// subsequent calls.
+func (c *Client) Negotiate(ctx context.Context) (string, error) {
+	disc, err := c.Discovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	remote := disc.SupportedApiVersions
+	if len(remote) == 0 {
+		remote = []string{disc.ApiVersion}
+	}
+	local := []string{ApiVersion}
+	for version := range DefaultMigrations {
+		local = append(local, version)
+	}
+	version, err := NegotiateVersion(local, remote)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.version = version
+	c.mu.Unlock()
+	return version, nil
 }
 
-// NewClient returns a new OpenGDPR client.
+// NewClient returns a new OpenGDPR client. It panics if
+// ClientOptions.ApiVersion cannot be spoken by the client.
 func NewClient(opts *ClientOptions) *Client {
-	cli := opts.Client
-	if cli == nil {
-		cli = http.DefaultClient
+	caller := opts.Caller
+	if caller == nil {
+		cli := opts.Client
+		if cli == nil {
+			cli = http.DefaultClient
+		}
+		caller = CallerFunc(cli.Do)
+	}
+	version := opts.ApiVersion
+	if version == "" {
+		version = ApiVersion
 	}
+	if _, ok := DefaultMigrations[version]; !ok && version != ApiVersion {
+		panic(ErrUnsupportedApiVersion(version))
+	}
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
 	client := &Client{
-		caller: &defaultCaller{
-			client: cli,
-			headers: map[string]string{
-				"GDPR-Version": ApiVersion,
-				"Content-Type": "Application/JSON",
-			},
-		},
-		endpoint: opts.Endpoint,
-		verifier: opts.Verifier,
+		caller:       caller,
+		endpoint:     opts.Endpoint,
+		verifier:     opts.Verifier,
+		version:      version,
+		headers:      headers,
+		replay:       newReplayOptions(opts.Replay),
+		retry:        opts.Retry,
+		timeout:      opts.Timeout,
+		ledger:       opts.Ledger,
+		domain:       opts.ProcessorDomain,
+		signer:       opts.Signer,
+		controllerId: opts.ControllerId,
+		metrics:      opts.Metrics,
+		tracer:       opts.Tracer,
+		logger:       opts.Logger,
+	}
+	if opts.Discovery != nil {
+		client.discovery = &discoveryCache{opts: opts.Discovery}
 	}
 	return client
 }
@@ -2,10 +2,13 @@ package gdpr
 
 import (
 	"bytes"
-	"io"
+	"context"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -81,7 +84,7 @@ type mockCaller struct {
 	err  error
 }
 
-func (m mockCaller) Call(string, string, io.Reader) (*http.Response, error) {
+func (m mockCaller) Call(*http.Request) (*http.Response, error) {
 	return m.resp, m.err
 }
 
@@ -95,7 +98,7 @@ func TestClientRequest(t *testing.T) {
 		StatusCode: 200,
 		Body:       ioutil.NopCloser(bytes.NewBuffer(mockResp)),
 	}, nil)
-	resp, err := c.Request(&Request{})
+	resp, err := c.Request(context.Background(), &Request{})
 	assert.NoError(t, err)
 	assert.Equal(t, "example_controller_id", resp.ControllerId)
 	assert.Equal(t, "a7551968-d5d6-44b2-9831-815ac9017798", resp.SubjectRequestId)
@@ -107,7 +110,7 @@ func TestClientStatus(t *testing.T) {
 		StatusCode: 200,
 		Body:       ioutil.NopCloser(bytes.NewBuffer(mockStatusResp)),
 	}, nil)
-	resp, err := c.Status("1234")
+	resp, err := c.Status(context.Background(), "1234")
 	assert.NoError(t, err)
 	assert.Equal(t, "a7551968-d5d6-44b2-9831-815ac9017798", resp.SubjectRequestId)
 	assert.Equal(t, "example_controller_id", resp.ControllerId)
@@ -121,7 +124,7 @@ func TestClientCancel(t *testing.T) {
 		StatusCode: 200,
 		Body:       ioutil.NopCloser(bytes.NewBuffer(mockCancellationResp)),
 	}, nil)
-	resp, err := c.Cancel("1234")
+	resp, err := c.Cancel(context.Background(), "1234")
 	assert.NoError(t, err)
 	assert.Equal(t, "a7551968-d5d6-44b2-9831-815ac9017798", resp.SubjectRequestId)
 	assert.Equal(t, "example_controller_id", resp.ControllerId)
@@ -134,7 +137,7 @@ func TestClientDiscover(t *testing.T) {
 		StatusCode: 200,
 		Body:       ioutil.NopCloser(bytes.NewBuffer(mockDiscoveryResp)),
 	}, nil)
-	resp, err := c.Discovery()
+	resp, err := c.Discovery(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, IDENTITY_EMAIL, resp.SupportedIdentities[0].Type)
 	assert.Equal(t, FORMAT_RAW, resp.SupportedIdentities[0].Format)
@@ -149,9 +152,141 @@ func TestClientError(t *testing.T) {
 		StatusCode: 500,
 		Body:       ioutil.NopCloser(bytes.NewBuffer(mockErrorResp)),
 	}, nil)
-	_, err := c.Request(&Request{})
+	_, err := c.Request(context.Background(), &Request{})
 	assert.Error(t, err)
 	assert.IsType(t, &ErrorResponse{}, err)
 	assert.Equal(t, "IllegalArgumentException", err.(*ErrorResponse).Errors[0].Reason)
 	assert.Equal(t, "subject_request_id field is required.", err.(*ErrorResponse).Errors[0].Message)
 }
+
+func TestClientNegotiate(t *testing.T) {
+	c := NewClient(&ClientOptions{Verifier: NoopVerifier{}})
+	c.caller = newMockCaller(&http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(`{"api_version":"1.0","supported_api_versions":["0.1"]}`))),
+	}, nil)
+	version, err := c.Negotiate(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1", version)
+	var sent string
+	c.caller = CallerFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r.Header.Get("GDPR-Version")
+		return newResponse(200, []byte(`{"ReceivedTime":"2018-10-02T15:00:00Z","api_version":"0.1"}`)), nil
+	})
+	resp, err := c.Cancel(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1", sent)
+	assert.Equal(t, 2018, resp.ReceivedTime.Year())
+	assert.Panics(t, func() { NewClient(&ClientOptions{ApiVersion: "2.0"}) })
+}
+
+func TestClientNegotiateConcurrent(t *testing.T) {
+	c := NewClient(&ClientOptions{Verifier: NoopVerifier{}})
+	c.caller = CallerFunc(func(r *http.Request) (*http.Response, error) {
+		return newResponse(200, []byte(`{"api_version":"1.0","supported_api_versions":["1.0","0.1"]}`)), nil
+	})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			c.Negotiate(context.Background())
+		}
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		c.Discovery(context.Background())
+	}
+	<-done
+}
+
+func TestCancellationResponseLegacy(t *testing.T) {
+	resp := &CancellationResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"subject_request_id":"1234","ReceivedTime":"2018-10-02T15:00:00Z"}`), resp))
+	assert.Equal(t, "1234", resp.SubjectRequestId)
+	assert.Equal(t, 2018, resp.ReceivedTime.Year())
+	resp = &CancellationResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"received_time":"2019-10-02T15:00:00Z"}`), resp))
+	assert.Equal(t, 2019, resp.ReceivedTime.Year())
+}
+
+// sequenceCaller returns each response in turn
+// recording the number of calls made.
+type sequenceCaller struct {
+	responses []*http.Response
+	calls     int
+}
+
+func (s *sequenceCaller) Call(req *http.Request) (*http.Response, error) {
+	resp := s.responses[s.calls]
+	s.calls++
+	if resp == nil {
+		return nil, errors.New("connection refused")
+	}
+	return resp, nil
+}
+
+func newResponse(code int, body []byte) *http.Response {
+	return &http.Response{StatusCode: code, Body: ioutil.NopCloser(bytes.NewBuffer(body))}
+}
+
+func TestClientRetry(t *testing.T) {
+	caller := &sequenceCaller{responses: []*http.Response{
+		nil,
+		newResponse(503, []byte("unavailable")),
+		newResponse(200, mockStatusResp),
+	}}
+	c := NewClient(&ClientOptions{
+		Verifier: NoopVerifier{},
+		Caller:   caller,
+		Retry:    &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	})
+	resp, err := c.Status(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, caller.calls)
+	assert.Equal(t, STATUS_PENDING, resp.RequestStatus)
+	// Requests are not retried by default
+	caller = &sequenceCaller{responses: []*http.Response{newResponse(503, []byte(`{"error":{"code":503,"message":"unavailable"}}`)), newResponse(200, mockResp)}}
+	c.caller = caller
+	_, err = c.Request(context.Background(), &Request{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, caller.calls)
+	// Unless the server is idempotent
+	caller = &sequenceCaller{responses: []*http.Response{newResponse(503, []byte(`{"error":{"code":503,"message":"unavailable"}}`)), newResponse(200, mockResp)}}
+	c.caller = caller
+	c.retry.RetryRequests = true
+	_, err = c.Request(context.Background(), &Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, caller.calls)
+	// 501 is never transient
+	caller = &sequenceCaller{responses: []*http.Response{newResponse(501, []byte("nope")), newResponse(200, mockStatusResp)}}
+	c.caller = caller
+	_, err = c.Status(context.Background(), "1234")
+	errResp := &ErrorResponse{}
+	assert.True(t, errors.As(err, &errResp))
+	assert.Equal(t, 501, errResp.Code)
+	assert.Equal(t, "nope", errResp.Message)
+	assert.Equal(t, 1, caller.calls)
+}
+
+func TestClientTransportError(t *testing.T) {
+	c := NewClient(&ClientOptions{
+		Endpoint: "http://localhost:1",
+		Verifier: NoopVerifier{},
+		Retry:    &RetryPolicy{MaxAttempts: 5, Backoff: time.Hour},
+		Timeout:  50 * time.Millisecond,
+	})
+	_, err := c.Status(context.Background(), "1234")
+	transportErr := &TransportError{}
+	assert.True(t, errors.As(err, &transportErr))
+	assert.False(t, Retryable(err))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	// Bad signatures are not transient
+	c = NewClient(&ClientOptions{
+		Verifier: MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+		Caller:   &sequenceCaller{responses: []*http.Response{newResponse(200, mockStatusResp)}},
+		Retry:    &RetryPolicy{MaxAttempts: 2},
+	})
+	_, err = c.Status(context.Background(), "1234")
+	assert.True(t, errors.As(err, &transportErr))
+	assert.Equal(t, 200, transportErr.StatusCode)
+	assert.False(t, Retryable(err))
+}
@@ -41,6 +41,9 @@ type KeyOptions struct {
 	// Optional byte string to decrypt
 	// a private key file.
 	Password []byte
+	// Sign or verify the canonical form
+	// of JSON payloads, see Canonicalize.
+	Canonical bool
 }
 
 func MustNewSigner(opts *KeyOptions) Signer {
@@ -51,17 +54,26 @@ func MustNewSigner(opts *KeyOptions) Signer {
 	return signer
 }
 
-// NewSigner creates a new RSA backed Signer
-func NewSigner(opts *KeyOptions) (Signer, error) {
-	privateKey := opts.KeyBytes
+// readKey returns the raw PEM bytes of
+// the key described by opts.
+func readKey(opts *KeyOptions) ([]byte, error) {
 	if opts.KeyPath != "" {
-		raw, err := ioutil.ReadFile(opts.KeyPath)
-		if err != nil {
-			return nil, err
-		}
-		privateKey = raw
+		return ioutil.ReadFile(opts.KeyPath)
+	}
+	return opts.KeyBytes, nil
+}
+
+// loadPrivateKey decodes a PKCS8 encoded
+// private key decrypting it if required.
+func loadPrivateKey(opts *KeyOptions) (interface{}, error) {
+	privateKey, err := readKey(opts)
+	if err != nil {
+		return nil, err
 	}
 	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
 	blockBytes := block.Bytes
 	// Decode the PEM key if a password is set
 	if x509.IsEncryptedPEMBlock(block) {
@@ -71,7 +83,26 @@ func NewSigner(opts *KeyOptions) (Signer, error) {
 		}
 		blockBytes = b
 	}
-	parsed, err := x509.ParsePKCS8PrivateKey(blockBytes)
+	return x509.ParsePKCS8PrivateKey(blockBytes)
+}
+
+// loadCertificate decodes a PEM encoded
+// x509 certificate.
+func loadCertificate(opts *KeyOptions) (*x509.Certificate, error) {
+	publicKey, err := readKey(opts)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// NewSigner creates a new RSA backed Signer
+func NewSigner(opts *KeyOptions) (Signer, error) {
+	parsed, err := loadPrivateKey(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +110,9 @@ func NewSigner(opts *KeyOptions) (Signer, error) {
 	if !ok {
 		return nil, fmt.Errorf("unsupported private key")
 	}
+	if opts.Canonical {
+		return CanonicalSigner(&rsaSigner{privKey: privKey}), nil
+	}
 	return &rsaSigner{privKey: privKey}, nil
 }
 
@@ -92,26 +126,20 @@ func MustNewVerifier(opts *KeyOptions) Verifier {
 
 // NewVerifier creates a new RSA backed Verifier
 func NewVerifier(opts *KeyOptions) (Verifier, error) {
-	publicKey := opts.KeyBytes
-	if opts.KeyPath != "" {
-		raw, err := ioutil.ReadFile(opts.KeyPath)
-		if err != nil {
-			return nil, err
-		}
-		publicKey = raw
-	}
-	block, _ := pem.Decode(publicKey)
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := loadCertificate(opts)
 	if err != nil {
 		return nil, err
 	}
-	// TODO
-	// Currently only support RSA keys
-	// Need to consider DSA/ECDSA
+	// Only RSA keys are supported by the default
+	// Verifier, see NewJWSVerifier for others.
 	if cert.PublicKeyAlgorithm != x509.RSA {
 		return nil, fmt.Errorf("unsupported public key type")
 	}
-	return &rsaVerifier{publicKey: cert.PublicKey.(*rsa.PublicKey), cert: cert}, nil
+	verifier := &rsaVerifier{publicKey: cert.PublicKey.(*rsa.PublicKey), cert: cert}
+	if opts.Canonical {
+		return CanonicalVerifier(verifier), nil
+	}
+	return verifier, nil
 }
 
 type rsaSigner struct {
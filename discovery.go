@@ -0,0 +1,186 @@
+package gdpr
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoveryOptions enable validation of outgoing requests
+// against the DiscoveryResponse of the remote processor
+// so unsupported requests fail before a round trip.
+type DiscoveryOptions struct {
+	// Duration a DiscoveryResponse is cached for,
+	// zero caches it for the lifetime of the Client.
+	TTL time.Duration
+	// Hash raw identities into a format supported by
+	// the processor when the raw format is not.
+	TransformIdentities bool
+	// Remove identities the processor does not support
+	// instead of failing the request. A request is still
+	// rejected if no supported identities remain.
+	DropUnsupported bool
+}
+
+// hashFormats are the hashed identity formats
+// ordered by preference.
+var hashFormats = []IdentityFormat{FORMAT_SHA256, FORMAT_SHA1, FORMAT_MD5}
+
+// HashIdentity converts a raw identity into the given
+// hashed format. Email addresses are trimmed and lower
+// cased before hashing.
+func HashIdentity(id Identity, format IdentityFormat) (Identity, error) {
+	if id.Format != FORMAT_RAW {
+		return id, ErrUnsupportedIdentity(id)
+	}
+	var h hash.Hash
+	switch format {
+	case FORMAT_SHA256:
+		h = sha256.New()
+	case FORMAT_SHA1:
+		h = sha1.New()
+	case FORMAT_MD5:
+		h = md5.New()
+	default:
+		return id, ErrUnsupportedIdentity(Identity{Type: id.Type, Format: format})
+	}
+	value := id.Value
+	if id.Type == IDENTITY_EMAIL {
+		value = strings.ToLower(strings.TrimSpace(value))
+	}
+	h.Write([]byte(value))
+	return Identity{
+		Type:   id.Type,
+		Format: format,
+		Value:  hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// discoveryCache holds the most recently fetched
+// DiscoveryResponse of a processor. The lock is not held
+// while fetching, concurrent lookups wait for the fetch
+// in flight or for their context to be done.
+type discoveryCache struct {
+	mu      sync.Mutex
+	opts    *DiscoveryOptions
+	resp    *DiscoveryResponse
+	fetched time.Time
+	// closed once the fetch in flight completes
+	fetching chan struct{}
+}
+
+func (d *discoveryCache) get(ctx context.Context, c *Client) (*DiscoveryResponse, error) {
+	for {
+		d.mu.Lock()
+		if d.resp != nil && (d.opts.TTL == 0 || time.Since(d.fetched) < d.opts.TTL) {
+			resp := d.resp
+			d.mu.Unlock()
+			return resp, nil
+		}
+		if d.fetching == nil {
+			done := make(chan struct{})
+			d.fetching = done
+			d.mu.Unlock()
+			resp, err := c.Discovery(ctx)
+			d.mu.Lock()
+			if err == nil {
+				d.resp, d.fetched = resp, time.Now()
+			}
+			d.fetching = nil
+			d.mu.Unlock()
+			close(done)
+			return resp, err
+		}
+		fetching := d.fetching
+		d.mu.Unlock()
+		// Fetch again if the fetch in flight fails
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Prepare validates a Request against the cached
+// DiscoveryResponse of the processor returning a copy
+// with any identities dropped or transformed according
+// to the DiscoveryOptions. An unmodified copy is returned
+// if discovery is not enabled, req itself is never
+// modified. Validation failures are returned as an
+// *ErrorResponse like those reported by the processor.
+func (c *Client) Prepare(ctx context.Context, req *Request) (*Request, error) {
+	prepared, err := c.prepare(ctx, req)
+	return prepared, clientError(err)
+}
+
+func (c *Client) prepare(ctx context.Context, req *Request) (*Request, error) {
+	if c.discovery == nil {
+		prepared := *req
+		return &prepared, nil
+	}
+	disc, err := c.discovery.get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	supported := false
+	for _, subjectType := range disc.SupportedSubjectRequestTypes {
+		if subjectType == req.SubjectRequestType {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, ErrUnsupportedRequestType(req.SubjectRequestType)
+	}
+	formats := map[IdentityType]map[IdentityFormat]bool{}
+	for _, id := range disc.SupportedIdentities {
+		if formats[id.Type] == nil {
+			formats[id.Type] = map[IdentityFormat]bool{}
+		}
+		formats[id.Type][id.Format] = true
+	}
+	opts := c.discovery.opts
+	prepared := *req
+	prepared.SubjectIdentities = nil
+	for _, id := range req.SubjectIdentities {
+		accepted, ok := acceptIdentity(id, formats[id.Type], opts.TransformIdentities)
+		if ok {
+			prepared.SubjectIdentities = append(prepared.SubjectIdentities, accepted)
+			continue
+		}
+		if !opts.DropUnsupported {
+			return nil, ErrUnsupportedIdentity(id)
+		}
+	}
+	if len(prepared.SubjectIdentities) == 0 {
+		if len(req.SubjectIdentities) > 0 {
+			return nil, ErrUnsupportedIdentity(req.SubjectIdentities[0])
+		}
+		return nil, ErrMissingRequiredField("subject_identities")
+	}
+	return &prepared, nil
+}
+
+// acceptIdentity returns the identity in a format
+// supported by the processor if possible.
+func acceptIdentity(id Identity, formats map[IdentityFormat]bool, transform bool) (Identity, bool) {
+	if formats[id.Format] {
+		return id, true
+	}
+	if !transform || id.Format != FORMAT_RAW {
+		return id, false
+	}
+	for _, format := range hashFormats {
+		if formats[format] {
+			hashed, err := HashIdentity(id, format)
+			return hashed, err == nil
+		}
+	}
+	return id, false
+}
@@ -0,0 +1,142 @@
+package gdpr
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashIdentity(t *testing.T) {
+	id, err := HashIdentity(Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: " JohnDoe@Example.com"}, FORMAT_SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, FORMAT_SHA256, id.Format)
+	assert.Equal(t, "55e79200c1635b37ad31a378c39feb12f120f116625093a19bc32fff15041149", id.Value)
+	_, err = HashIdentity(id, FORMAT_MD5)
+	assert.Error(t, err)
+}
+
+func TestClientPrepare(t *testing.T) {
+	var requests []*Request
+	calls := 0
+	c := NewClient(&ClientOptions{
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			if r.URL.Path == "/discovery" {
+				return newResponse(200, []byte(`{
+					"supported_identities":[{"identity_type":"email","identity_format":"sha256"}],
+					"supported_subject_request_types":["erasure"]
+				}`)), nil
+			}
+			req := &Request{}
+			raw, _ := ioutil.ReadAll(r.Body)
+			assert.NoError(t, json.Unmarshal(raw, req))
+			requests = append(requests, req)
+			return newResponse(201, mockResp), nil
+		}),
+		Discovery: &DiscoveryOptions{},
+	})
+	email := Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}
+	android := Identity{Type: IDENTITY_ANDROID_ID, Format: FORMAT_RAW, Value: "1234"}
+	// Unsupported subject types fail locally
+	_, err := c.Request(context.Background(), &Request{
+		SubjectRequestType: SUBJECT_PORTABILITY,
+		SubjectIdentities:  []Identity{email},
+	})
+	assert.Equal(t, 501, err.(*ErrorResponse).Code)
+	// Unsupported identity formats fail locally
+	_, err = c.Request(context.Background(), &Request{
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{email},
+	})
+	assert.Equal(t, 501, err.(*ErrorResponse).Code)
+	assert.Len(t, requests, 0)
+	// Raw identities are hashed and unsupported
+	// identities dropped when enabled
+	c.discovery.opts.TransformIdentities = true
+	c.discovery.opts.DropUnsupported = true
+	original := &Request{
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{android, email},
+	}
+	_, err = c.Request(context.Background(), original)
+	assert.NoError(t, err)
+	assert.Len(t, requests, 1)
+	assert.Equal(t, []Identity{Identity{
+		Type:   IDENTITY_EMAIL,
+		Format: FORMAT_SHA256,
+		Value:  "55e79200c1635b37ad31a378c39feb12f120f116625093a19bc32fff15041149",
+	}}, requests[0].SubjectIdentities)
+	// The callers request is not modified
+	assert.Equal(t, []Identity{android, email}, original.SubjectIdentities)
+	assert.Equal(t, "", original.ApiVersion)
+	// Requests without any supported identities still fail
+	_, err = c.Request(context.Background(), &Request{
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{android},
+	})
+	assert.Error(t, err)
+	// Discovery is only fetched once
+	assert.Equal(t, 2, calls)
+}
+
+func TestClientRequestUnmodified(t *testing.T) {
+	c := NewClient(&ClientOptions{
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			return newResponse(201, mockResp), nil
+		}),
+	})
+	req := &Request{SubjectRequestId: "1234", SubjectRequestType: SUBJECT_ERASURE}
+	_, err := c.Request(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "", req.ApiVersion)
+}
+
+func TestClientDiscoveryInFlight(t *testing.T) {
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	c := NewClient(&ClientOptions{
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(started)
+			}
+			<-release
+			return newResponse(200, []byte(`{
+				"supported_identities":[{"identity_type":"email","identity_format":"raw"}],
+				"supported_subject_request_types":["erasure"]
+			}`)), nil
+		}),
+		Discovery: &DiscoveryOptions{},
+	})
+	req := &Request{
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	}
+	first := make(chan error)
+	go func() {
+		_, err := c.Prepare(context.Background(), req)
+		first <- err
+	}()
+	<-started
+	// A slow discovery does not block lookups
+	// past the end of their context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Prepare(ctx, req)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	close(release)
+	assert.NoError(t, <-first)
+	_, err = c.Prepare(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -1,10 +1,24 @@
 package gdpr
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
+// clientError returns an ErrorResponse value as an
+// *ErrorResponse so errors returned to users of a Client
+// have the same form whether they were detected locally
+// or reported by the remote server.
+func clientError(err error) error {
+	if errResp, ok := err.(ErrorResponse); ok {
+		return &errResp
+	}
+	return err
+}
+
 // ErrNotFound indicates a request could
 // not be found by the processor.
 func ErrNotFound(id string) error {
@@ -50,3 +64,162 @@ func ErrInvalidRequestSignature(signature string, err error) error {
 		Errors:  []Error{Error{Message: err.Error()}},
 	}
 }
+
+// ErrUnsupportedApiVersion indicates the server cannot
+// understand the requested version of the specification.
+func ErrUnsupportedApiVersion(version string) error {
+	return ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("unsupported api version: %s", version),
+	}
+}
+
+// ErrDuplicateRequest indicates a request with the same
+// subject_request_id but a different payload has already
+// been submitted.
+func ErrDuplicateRequest(id string) error {
+	return ErrorResponse{
+		Code:    http.StatusConflict,
+		Message: fmt.Sprintf("request %s already submitted with a different payload", id),
+	}
+}
+
+// ErrSubmissionInProgress indicates a request
+// with the same subject_request_id is still
+// being processed and should be retried later.
+func ErrSubmissionInProgress(id string) error {
+	return ErrorResponse{
+		Code:    http.StatusServiceUnavailable,
+		Message: fmt.Sprintf("request %s is still being processed", id),
+	}
+}
+
+// ErrStaleMessage indicates the signed timestamp of a
+// message falls outside of the allowed clock skew.
+func ErrStaleMessage(timestamp string) error {
+	return ErrorResponse{
+		Code:    http.StatusForbidden,
+		Message: fmt.Sprintf("message timestamp is stale: %s", timestamp),
+	}
+}
+
+// ErrReplayedMessage indicates a message with the
+// same nonce has already been received.
+func ErrReplayedMessage(nonce string) error {
+	return ErrorResponse{
+		Code:    http.StatusForbidden,
+		Message: fmt.Sprintf("message has already been received: %s", nonce),
+	}
+}
+
+// ErrInvalidJSON indicates a payload could
+// not be decoded as JSON.
+func ErrInvalidJSON(reason string) error {
+	return ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid json: %s", reason),
+	}
+}
+
+// TransportError indicates a Client call failed before
+// a response was received or the response could not be
+// verified or decoded. Errors reported by the remote
+// server are returned as an *ErrorResponse instead.
+type TransportError struct {
+	Method string
+	URL    string
+	// Status code of the response if one
+	// was received.
+	StatusCode int
+	Err        error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Err)
+}
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// Temporary reports whether the error was caused by a
+// connection failure which may succeed if retried.
+func (e *TransportError) Temporary() bool {
+	if e.StatusCode != 0 {
+		return false
+	}
+	return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// ErrUnknownProcessor indicates a message was received
+// from a processor which is not registered.
+func ErrUnknownProcessor(domain string) error {
+	return ErrorResponse{
+		Code:    http.StatusForbidden,
+		Message: fmt.Sprintf("unknown processor: %s", domain),
+	}
+}
+
+// ErrInvalidParameter indicates a query
+// parameter could not be parsed.
+func ErrInvalidParameter(name, value string) error {
+	return ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid %s: %s", name, value),
+	}
+}
+
+// ErrUnauthorized indicates the request could
+// not be authenticated.
+func ErrUnauthorized(reason string) error {
+	return ErrorResponse{
+		Code:    http.StatusUnauthorized,
+		Message: fmt.Sprintf("unauthorized: %s", reason),
+	}
+}
+
+// ErrBatchTooLarge indicates a batch contains
+// more than MaxBatchSize requests.
+func ErrBatchTooLarge(size int) error {
+	return ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("batch of %d requests exceeds limit of %d", size, MaxBatchSize),
+	}
+}
+
+// ErrRateLimited indicates the caller has exceeded a
+// rate limit or quota and may retry after the given
+// duration.
+func ErrRateLimited(reason string, retryAfter time.Duration) error {
+	return ErrorResponse{
+		Code:    http.StatusTooManyRequests,
+		Message: fmt.Sprintf("rate limited: %s, retry after %s", reason, retryAfter),
+	}
+}
+
+// ErrBodyTooLarge indicates a request body
+// exceeded the maximum size of the server.
+func ErrBodyTooLarge(limit int64) error {
+	return ErrorResponse{
+		Code:    http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("request body exceeds %d bytes", limit),
+	}
+}
+
+// ErrSigningFailed indicates the server
+// could not sign it's response.
+func ErrSigningFailed() error {
+	return ErrorResponse{
+		Code:    http.StatusInternalServerError,
+		Message: "could not sign response",
+	}
+}
+
+// PanicError is reported to Hooks.OnError when a
+// panic is recovered while serving a request.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
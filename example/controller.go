@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -31,7 +32,7 @@ func main() {
 		Endpoint: "http://localhost:4000",
 		Verifier: gdpr.NoopVerifier{},
 	})
-	_, err := client.Request(&gdpr.Request{
+	_, err := client.Request(context.Background(), &gdpr.Request{
 		SubjectRequestId:   "request-1234",
 		SubjectRequestType: gdpr.SUBJECT_ACCESS,
 		SubjectIdentities: []gdpr.Identity{
@@ -1,27 +1,23 @@
 package main
 
 import (
+	"context"
 	"log"
-	"sync"
 
 	"github.com/satori/go.uuid"
 
 	"github.com/greencase/go-gdpr"
 )
 
+// Controller sends requests through a Client configured
+// with a Ledger so callbacks can be reconciled against
+// them after a restart.
 type Controller struct {
-	mu        sync.RWMutex
-	client    *gdpr.Client
-	responses map[string]*gdpr.Response
+	client *gdpr.Client
 }
 
 func (c *Controller) Callback(cb *gdpr.CallbackRequest) error {
 	log.Printf("received callback request for %s: %s\n", cb.SubjectRequestId, cb.RequestStatus)
-	if cb.RequestStatus == gdpr.STATUS_COMPLETED {
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		delete(c.responses, cb.SubjectRequestId)
-	}
 	return nil
 }
 
@@ -42,13 +38,10 @@ func (c *Controller) Request() error {
 			"http://localhost:4001/opengdpr_callbacks",
 		},
 	}
-	resp, err := c.client.Request(req)
+	resp, err := c.client.Request(context.Background(), req)
 	if err != nil {
 		return err
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.responses[resp.SubjectRequestId] = resp
 	log.Printf("sent new gdpr request: %s", resp.SubjectRequestId)
 	return nil
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/greencase/go-gdpr"
@@ -15,6 +19,20 @@ func maybe(err error) {
 	}
 }
 
+// interrupted returns a context which is
+// done on SIGINT or SIGTERM.
+func interrupted() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Println("shutting down")
+		cancel()
+	}()
+	return ctx
+}
+
 func main() {
 	var (
 		interval       = flag.String("interval", "1s", "interval to generate new requests at")
@@ -43,11 +61,13 @@ func main() {
 		proc := &Processor{
 			queue:  make(chan *dbState),
 			db:     db,
+			domain: "localhost",
 			signer: signer,
 		}
 		svr := gdpr.NewServer(&gdpr.ServerOptions{
-			Signer:    signer,
-			Processor: proc,
+			Signer:          signer,
+			Processor:       proc,
+			ProcessorDomain: "localhost",
 			Identities: []gdpr.Identity{
 				gdpr.Identity{
 					Type:   gdpr.IDENTITY_EMAIL,
@@ -62,45 +82,84 @@ func main() {
 		})
 		// Log the signature generated from the response body which
 		// is sent to the controller for verification.
-		svr.After(func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("signature=%s\n", w.Header().Get("X-OpenGDPR-Signature"))
+		svr.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+				log.Printf("signature=%s\n", w.Header().Get("X-OpenGDPR-Signature"))
+			})
+		})
+		// Serve requests and process them in the background
+		// until interrupted, callbacks which have not been
+		// sent are resumed on the next start.
+		proc.runner = gdpr.NewRunner(&gdpr.RunnerOptions{
+			Handler: svr,
+			Addr:    ":4000",
+			Workers: []gdpr.Worker{proc},
+			OnError: func(err error) { log.Printf("error: %s\n", err) },
 		})
-		go func() {
-			// Start the HTTP server in the background
-			log.Println("server listening @ :4000")
-			maybe(http.ListenAndServe(":4000", svr))
-		}()
-		maybe(proc.Process())
+		log.Println("server listening @ :4000")
+		maybe(proc.runner.Run(interrupted()))
 		return
 	}
 	// Run a Controller
 	if *controller {
 		sleepInterval, err := time.ParseDuration(*interval)
 		maybe(err)
-		contr := &Controller{
-			client: gdpr.NewClient(&gdpr.ClientOptions{
-				Endpoint: "http://localhost:4000",
-				Verifier: verifier,
-			}),
-			responses: map[string]*gdpr.Response{},
-		}
+		ledger, err := gdpr.NewFileLedger("ledger.json")
+		maybe(err)
+		client := gdpr.NewClient(&gdpr.ClientOptions{
+			Endpoint:        "http://localhost:4000",
+			Verifier:        verifier,
+			Ledger:          ledger,
+			ProcessorDomain: "localhost",
+		})
+		contr := &Controller{client: client}
 		svr := gdpr.NewServer(&gdpr.ServerOptions{
 			Verifier:   verifier,
 			Controller: contr,
+			Ledger:     ledger,
+		})
+		// Poll the status of any requests whose
+		// callbacks never arrived
+		reconciler := gdpr.NewReconciler(&gdpr.ReconcilerOptions{
+			Ledger:     ledger,
+			Controller: contr,
+			Clients:    map[string]*gdpr.Client{"localhost": client},
+			After:      time.Minute,
 		})
 		// Log the signature generated from the processor which is present
 		// on each callback to the controller.
-		svr.Before(func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("signature=%s\n", r.Header.Get("X-OpenGDPR-Signature"))
+		svr.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("signature=%s\n", r.Header.Get("X-OpenGDPR-Signature"))
+				next.ServeHTTP(w, r)
+			})
 		})
-		// Start the HTTP server in the background
-		go func() {
-			log.Println("server listening @ :4001")
-			maybe(http.ListenAndServe(":4001", svr))
-		}()
-		for {
-			maybe(contr.Request())
-			time.Sleep(sleepInterval)
-		}
+		runner := gdpr.NewRunner(&gdpr.RunnerOptions{
+			Handler: svr,
+			Addr:    ":4001",
+			Workers: []gdpr.Worker{
+				gdpr.WorkerFunc(func(ctx context.Context) error {
+					return reconciler.Run(ctx, func(err error) {
+						log.Printf("reconciliation failed: %s\n", err)
+					})
+				}),
+				gdpr.WorkerFunc(func(ctx context.Context) error {
+					for {
+						if err := contr.Request(); err != nil {
+							return err
+						}
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case <-time.After(sleepInterval):
+						}
+					}
+				}),
+			},
+			OnError: func(err error) { log.Printf("error: %s\n", err) },
+		})
+		log.Println("server listening @ :4001")
+		maybe(runner.Run(interrupted()))
 	}
 }
@@ -1,25 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/greencase/go-gdpr"
 )
 
-// Done is used to communicate a callback
-// was completed.
-type Done struct {
-	SubjectRequestId string
-	Err              error
-}
-
 // SQLite backed OpenGDPR processor implementation
 type Processor struct {
 	db     *Database
 	domain string
 	queue  chan *dbState
 	signer gdpr.Signer
+	runner *gdpr.Runner
 }
 
 func (p *Processor) Request(req *gdpr.Request) (*gdpr.Response, error) {
@@ -78,45 +73,65 @@ func (p *Processor) Cancel(requestId string) (*gdpr.CancellationResponse, error)
 	}, nil
 }
 
-func (p *Processor) process(request *dbState, doneCh chan Done) {
+// process sends each callback for the request and marks it
+// as completed. Requests interrupted by shutdown are left
+// pending and resumed when the processor next starts.
+func (p *Processor) process(ctx context.Context, request *dbState) error {
 	for _, cbUrl := range request.StatusCallbackUrls {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		log.Printf("sending callback: %s", cbUrl)
 		cbReq := &gdpr.CallbackRequest{
 			SubjectRequestId:  request.SubjectRequestId,
 			RequestStatus:     gdpr.STATUS_COMPLETED,
 			StatusCallbackUrl: cbUrl,
 		}
-		err := gdpr.Callback(cbReq, &gdpr.CallbackOptions{
+		err := gdpr.CallbackContext(ctx, cbReq, &gdpr.CallbackOptions{
 			MaxAttempts:     3,
 			ProcessorDomain: p.domain,
 			Backoff:         5 * time.Second,
 			Signer:          p.signer,
 		})
-		doneCh <- Done{
-			SubjectRequestId: request.SubjectRequestId,
-			Err:              err,
+		if ctx.Err() != nil {
+			// Interrupted, leave the request pending
+			return ctx.Err()
+		}
+		if err != nil {
+			// BUG: The OpenGDPR specification doesn't say what should happen
+			// when Callback requests fail so we just mark it as COMPLETED.
+			log.Printf("callback for request %s failed: %s\n", request.SubjectRequestId, err)
 		}
 	}
+	err := p.db.SetStatus(request.SubjectRequestId, gdpr.STATUS_COMPLETED)
+	if err != nil {
+		return err
+	}
+	log.Printf("request %s marked as completed \n", request.SubjectRequestId)
+	return nil
 }
 
-func (p *Processor) Process() error {
-	doneCh := make(chan Done)
+// Run resumes any pending requests and then processes
+// new requests until the context is done.
+func (p *Processor) Run(ctx context.Context) error {
+	pending, err := p.db.Pending()
+	if err != nil {
+		return err
+	}
+	for _, req := range pending {
+		req := req
+		if err := p.runner.Go(func(ctx context.Context) error { return p.process(ctx, req) }); err != nil {
+			return err
+		}
+	}
 	for {
 		select {
 		case req := <-p.queue:
-			go p.process(req, doneCh)
-		case done := <-doneCh:
-			if done.Err != nil {
-				// BUG: The OpenGDPR specification doesn't say what should happen
-				// when Callback requests fail so we just mark it as COMPLETED.
-				log.Printf("callback for request %s failed: %s\n", done.SubjectRequestId, done.Err)
-			}
-			err := p.db.SetStatus(done.SubjectRequestId, gdpr.STATUS_COMPLETED)
-			if err != nil {
+			if err := p.runner.Go(func(ctx context.Context) error { return p.process(ctx, req) }); err != nil {
 				return err
 			}
-			log.Printf("request %s marked as completed \n", done.SubjectRequestId)
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	return nil
 }
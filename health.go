@@ -0,0 +1,279 @@
+package gdpr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCheckTimeout is the time allowed for a
+	// HealthCheck without a Timeout to complete.
+	DefaultCheckTimeout = 5 * time.Second
+	// SignerCheckInterval is the minimum time between
+	// signatures made by the built in signer check.
+	SignerCheckInterval = time.Minute
+	// ReadyCacheInterval is the minimum time between runs
+	// of the checks for /readyz probes which are not
+	// authenticated by ServerOptions.Authenticator, they
+	// receive the cached status in between.
+	ReadyCacheInterval = 10 * time.Second
+)
+
+// HealthCheck reports whether a dependency
+// of the Server is able to work.
+type HealthCheck struct {
+	Name string
+	// Time allowed for Check to complete,
+	// defaults to DefaultCheckTimeout.
+	Timeout time.Duration
+	Check   func(ctx context.Context) error
+}
+
+// HealthResponse is served by /healthz and /readyz with
+// the result of each check, "ok" or the error. The
+// results are only served to callers authenticated by
+// ServerOptions.Authenticator, others receive the status
+// alone which /readyz caches, see ReadyCacheInterval.
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// signerHealth checks the Signer can sign at most
+// once per SignerCheckInterval.
+type signerHealth struct {
+	signer   Signer
+	mu       sync.Mutex
+	checked  time.Time
+	err      error
+	inflight bool
+}
+
+func (h *signerHealth) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	// Reuse the last result while it is fresh or
+	// while another probe is already signing.
+	if h.inflight || (!h.checked.IsZero() && time.Since(h.checked) < SignerCheckInterval) {
+		err := h.err
+		h.mu.Unlock()
+		return err
+	}
+	h.inflight = true
+	h.mu.Unlock()
+	_, err := h.signer.Sign([]byte("healthcheck"))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inflight, h.checked, h.err = false, time.Now(), err
+	return err
+}
+
+// signerCheck checks the Signer can sign.
+func signerCheck(signer Signer) HealthCheck {
+	return HealthCheck{
+		Name:  "signer",
+		Check: (&signerHealth{signer: signer}).check,
+	}
+}
+
+// verifierCheck checks the certificate of the
+// Verifier is currently valid.
+func verifierCheck(name string, verifier Verifier) HealthCheck {
+	return HealthCheck{
+		Name: name,
+		Check: func(context.Context) error {
+			cert := verifier.Cert()
+			if cert == nil {
+				return nil
+			}
+			now := time.Now()
+			if now.Before(cert.NotBefore) {
+				return fmt.Errorf("certificate is not valid until %s", cert.NotBefore)
+			}
+			if now.After(cert.NotAfter) {
+				return fmt.Errorf("certificate expired at %s", cert.NotAfter)
+			}
+			return nil
+		},
+	}
+}
+
+// defaultChecks returns the built in checks of
+// the Signer and Verifiers configured by opts.
+func defaultChecks(opts *ServerOptions) []HealthCheck {
+	var checks []HealthCheck
+	if hasProcessor(opts) && opts.Signer != nil {
+		checks = append(checks, signerCheck(opts.Signer))
+	}
+	if hasController(opts) && opts.Verifier != nil {
+		checks = append(checks, verifierCheck("verifier", opts.Verifier))
+	}
+	for domain, verifier := range opts.Verifiers {
+		checks = append(checks, verifierCheck("verifier:"+domain, verifier))
+	}
+	return checks
+}
+
+// run performs the check returning an error if it
+// fails or does not complete within it's timeout.
+func (c HealthCheck) run(ctx context.Context) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if value := recover(); value != nil {
+				result <- fmt.Errorf("panic: %v", value)
+			}
+		}()
+		result <- c.Check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// AddCheck registers a HealthCheck
+// which is run by /readyz.
+func (s *Server) AddCheck(check HealthCheck) {
+	s.checksMu.Lock()
+	s.checks = append(s.checks, check)
+	s.checksMu.Unlock()
+	s.ready.mu.Lock()
+	s.ready.resp = nil
+	s.ready.mu.Unlock()
+}
+
+// readyCache holds the result of the last run of the
+// checks for unauthenticated probes.
+type readyCache struct {
+	mu      sync.Mutex
+	resp    *HealthResponse
+	checked time.Time
+	// closed once the run in flight completes
+	running chan struct{}
+}
+
+// cachedReady returns the result of Ready, running the
+// checks at most once per ReadyCacheInterval.
+func (s *Server) cachedReady(ctx context.Context) *HealthResponse {
+	c := s.ready
+	for {
+		c.mu.Lock()
+		if c.resp != nil && time.Since(c.checked) < ReadyCacheInterval {
+			resp := c.resp
+			c.mu.Unlock()
+			return resp
+		}
+		if c.running == nil {
+			done := make(chan struct{})
+			c.running = done
+			c.mu.Unlock()
+			// Not bound to the probe so an abandoned
+			// probe does not cache a failure, each
+			// check has it's own timeout.
+			resp := s.Ready(context.Background())
+			c.mu.Lock()
+			c.resp, c.checked, c.running = resp, time.Now(), nil
+			c.mu.Unlock()
+			close(done)
+			return resp
+		}
+		running := c.running
+		c.mu.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return &HealthResponse{Status: "fail"}
+		}
+	}
+}
+
+// Ready runs every HealthCheck in parallel.
+func (s *Server) Ready(ctx context.Context) *HealthResponse {
+	s.checksMu.Lock()
+	checks := append([]HealthCheck{}, s.checks...)
+	s.checksMu.Unlock()
+	resp := &HealthResponse{Status: "ok", Checks: map[string]string{}}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			err := check.run(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				resp.Status = "fail"
+				resp.Checks[check.Name] = err.Error()
+			} else {
+				resp.Checks[check.Name] = "ok"
+			}
+		}(check)
+	}
+	wg.Wait()
+	if err := s.Healthy(); err != nil {
+		resp.Status = "fail"
+		resp.Checks["healthy"] = err.Error()
+	}
+	return resp
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter, operator bool, resp *HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// Errors may reveal internal details
+	if !operator {
+		resp = &HealthResponse{Status: resp.Status}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// operator reports whether the request is
+// authenticated by ServerOptions.Authenticator.
+func (s *Server) operator(r *http.Request) bool {
+	if s.authenticator == nil {
+		return false
+	}
+	_, err := s.authenticator.Authenticate(r)
+	return err == nil
+}
+
+// healthz reports whether the Server is alive.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	resp := &HealthResponse{Status: "ok"}
+	if err := s.Healthy(); err != nil {
+		resp.Status = "fail"
+		resp.Checks = map[string]string{"healthy": err.Error()}
+	}
+	s.writeHealth(w, s.operator(r), resp)
+}
+
+// readyz reports whether every HealthCheck passes. The
+// checks are only run on every probe for operators.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.operator(r) {
+		s.writeHealth(w, true, s.Ready(r.Context()))
+		return
+	}
+	s.writeHealth(w, false, s.cachedReady(r.Context()))
+}
@@ -0,0 +1,125 @@
+package gdpr
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type expiredVerifier struct {
+	NoopVerifier
+}
+
+func (expiredVerifier) Cert() *x509.Certificate {
+	return &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+}
+
+// operatorAuth authenticates operators with a bearer token.
+var operatorAuth = NewTokenAuthenticator(map[string]string{"secret": "operator"})
+
+func health(t *testing.T, server *Server, path string) (int, *HealthResponse) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	server.ServeHTTP(w, r)
+	resp := &HealthResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	return w.Code, resp
+}
+
+func TestServerHealth(t *testing.T) {
+	_, proc := newServer()
+	server := NewServer(&ServerOptions{
+		Signer:        MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+		Processor:     proc,
+		Authenticator: operatorAuth,
+	})
+	code, resp := health(t, server, "/healthz")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", resp.Status)
+	code, resp = health(t, server, "/readyz")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", resp.Checks["signer"])
+	// Custom checks with timeouts
+	server.AddCheck(HealthCheck{
+		Name: "database",
+		Check: func(context.Context) error {
+			return errors.New("connection refused")
+		},
+	})
+	server.AddCheck(HealthCheck{
+		Name:    "queue",
+		Timeout: 10 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+	code, resp = health(t, server, "/readyz")
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "fail", resp.Status)
+	assert.Equal(t, "connection refused", resp.Checks["database"])
+	assert.Contains(t, resp.Checks["queue"], "timed out")
+	// Liveness does not run checks
+	code, _ = health(t, server, "/healthz")
+	assert.Equal(t, 200, code)
+	// Failing signer
+	server = NewServer(&ServerOptions{Signer: failingSigner{}, Processor: proc, SignerPolicy: SIGNER_UNHEALTHY, Authenticator: operatorAuth})
+	code, resp = health(t, server, "/readyz")
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "hsm unavailable", resp.Checks["signer"])
+	code, _ = health(t, server, "/healthz")
+	assert.Equal(t, 200, code)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests/1234", nil))
+	code, _ = health(t, server, "/healthz")
+	assert.Equal(t, 503, code)
+	// Expired verifier certificate
+	server = NewServer(&ServerOptions{Controller: &mockController{}, Verifier: expiredVerifier{}, Authenticator: operatorAuth})
+	code, resp = health(t, server, "/readyz")
+	assert.Equal(t, 503, code)
+	assert.Contains(t, resp.Checks["verifier"], "expired")
+}
+
+type countingSigner struct {
+	NoopSigner
+	calls int32
+}
+
+func (c *countingSigner) Sign(body []byte) (string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.NoopSigner.Sign(body)
+}
+
+func TestServerHealthPublic(t *testing.T) {
+	_, proc := newServer()
+	signer := &countingSigner{}
+	server := NewServer(&ServerOptions{Signer: signer, Processor: proc})
+	var checks int32
+	server.AddCheck(HealthCheck{
+		Name: "database",
+		Check: func(context.Context) error {
+			atomic.AddInt32(&checks, 1)
+			return errors.New("dial tcp 10.0.0.1:5432: connection refused")
+		},
+	})
+	// Only the status is served without authentication
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.JSONEq(t, `{"status":"fail"}`, w.Body.String())
+	// Nor are the checks run on every probe
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&checks))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&signer.calls))
+}
@@ -0,0 +1,137 @@
+package gdpr
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Middleware wraps the http.Handler of a Server. Middleware
+// may short-circuit a request by not calling the next
+// handler or wrap the http.ResponseWriter to inspect or
+// modify the response.
+type Middleware func(http.Handler) http.Handler
+
+// Hooks are called around each OpenGDPR operation served by a
+// Server with the decoded payload. Returning an error from a
+// Before hook rejects the operation with that error, the
+// error returned from an After hook replaces the error of
+// the operation, which may only be nil when the operation
+// has a response. The status hooks are
+// also called for each request of a batch status query or
+// listing. Any hook may be nil.
+type Hooks struct {
+	BeforeRequest  func(req *Request) error
+	AfterRequest   func(req *Request, resp *Response, err error) error
+	BeforeStatus   func(id string) error
+	AfterStatus    func(id string, resp *StatusResponse, err error) error
+	BeforeCancel   func(id string) error
+	AfterCancel    func(id string, resp *CancellationResponse, err error) error
+	BeforeCallback func(req *CallbackRequest) error
+	AfterCallback  func(req *CallbackRequest, err error) error
+	// OnError is called with panics recovered while
+	// serving a request, as a *PanicError, and with
+	// failures to sign a response.
+	OnError func(r *http.Request, err error)
+}
+
+func (h *Hooks) request(req *Request, fn func() (*Response, error)) (*Response, error) {
+	if h != nil && h.BeforeRequest != nil {
+		if err := h.BeforeRequest(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := fn()
+	if h != nil && h.AfterRequest != nil {
+		err = h.AfterRequest(req, resp, err)
+		if err == nil && resp == nil {
+			err = errNoResponse("AfterRequest", req.SubjectRequestId)
+		}
+	}
+	return resp, err
+}
+
+func (h *Hooks) status(id string, fn func() (*StatusResponse, error)) (*StatusResponse, error) {
+	if err := h.beforeStatus(id); err != nil {
+		return nil, err
+	}
+	resp, err := fn()
+	return resp, h.afterStatus(id, resp, err)
+}
+
+func (h *Hooks) beforeStatus(id string) error {
+	if h != nil && h.BeforeStatus != nil {
+		return h.BeforeStatus(id)
+	}
+	return nil
+}
+
+func (h *Hooks) afterStatus(id string, resp *StatusResponse, err error) error {
+	if h != nil && h.AfterStatus != nil {
+		err = h.AfterStatus(id, resp, err)
+		if err == nil && resp == nil {
+			err = errNoResponse("AfterStatus", id)
+		}
+	}
+	return err
+}
+
+func (h *Hooks) cancel(id string, fn func() (*CancellationResponse, error)) (*CancellationResponse, error) {
+	if h != nil && h.BeforeCancel != nil {
+		if err := h.BeforeCancel(id); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := fn()
+	if h != nil && h.AfterCancel != nil {
+		err = h.AfterCancel(id, resp, err)
+		if err == nil && resp == nil {
+			err = errNoResponse("AfterCancel", id)
+		}
+	}
+	return resp, err
+}
+
+// errNoResponse is returned when an After hook clears
+// the error of an operation which has no response.
+func errNoResponse(hook, id string) error {
+	return fmt.Errorf("%s hook cleared the error of %s which has no response", hook, id)
+}
+
+func (h *Hooks) callback(req *CallbackRequest, fn func() error) error {
+	if h != nil && h.BeforeCallback != nil {
+		if err := h.BeforeCallback(req); err != nil {
+			return err
+		}
+	}
+	err := fn()
+	if h != nil && h.AfterCallback != nil {
+		err = h.AfterCallback(req, err)
+	}
+	return err
+}
+
+// Use adds middleware to the Server. Middleware run in the
+// order they are added with the first being outermost and
+// wrap routing, authentication and signature verification.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+	s.build()
+}
+
+// wrap adds middleware outside of everything added so far,
+// preserving the order in which Before and After run
+// their handlers.
+func (s *Server) wrap(middleware Middleware) {
+	s.middleware = append([]Middleware{middleware}, s.middleware...)
+	s.build()
+}
+
+// build chains the middleware around the router.
+func (s *Server) build() {
+	var handler http.Handler = s.router
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	handler = s.recoverHandler(handler)
+	s.handlerFn = handler.ServeHTTP
+}
@@ -0,0 +1,185 @@
+package gdpr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerUse(t *testing.T) {
+	server, _ := newServer()
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	server.Use(tag("first"), tag("second"))
+	server.After(func(w http.ResponseWriter, r *http.Request) { order = append(order, "after") })
+	server.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Block") != "" {
+				w.WriteHeader(http.StatusTeapot)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/discovery", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []string{"first", "second", "after"}, order)
+	r := httptest.NewRequest("GET", "/discovery", nil)
+	r.Header.Set("X-Block", "true")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+}
+
+func TestServerHooks(t *testing.T) {
+	var (
+		received []string
+		statuses []*StatusResponse
+	)
+	hooks := &Hooks{
+		BeforeRequest: func(req *Request) error {
+			if req.SubjectRequestType == SUBJECT_ERASURE {
+				return ErrorResponse{Code: http.StatusForbidden, Message: "erasure is paused"}
+			}
+			return nil
+		},
+		AfterStatus: func(id string, resp *StatusResponse, err error) error {
+			statuses = append(statuses, resp)
+			return err
+		},
+		AfterCancel: func(id string, resp *CancellationResponse, err error) error {
+			if err != nil {
+				return ErrNotFound(id)
+			}
+			return nil
+		},
+	}
+	server, proc := newServer()
+	server = NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		Hooks:        hooks,
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+	assert.Equal(t, 403, w.Code)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests/1234", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, STATUS_PENDING, statuses[0].RequestStatus)
+	// The error of the operation is replaced
+	proc.err = errors.New("database unavailable")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("DELETE", "/opengdpr_requests/1234", nil))
+	assert.Equal(t, 404, w.Code)
+	// Callback hooks
+	controller := &mockController{}
+	server = NewServer(&ServerOptions{
+		Controller: controller,
+		Verifier:   NoopVerifier{},
+		Hooks: &Hooks{
+			BeforeCallback: func(req *CallbackRequest) error {
+				received = append(received, req.SubjectRequestId)
+				return nil
+			},
+		},
+	})
+	body, _ := json.Marshal(&CallbackRequest{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED})
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body)))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []string{"1234"}, received)
+	assert.Len(t, controller.callbacks, 1)
+}
+
+func TestServerHooksNoResponse(t *testing.T) {
+	// After hooks which clear the error of an
+	// operation without a response
+	server, proc := newServer()
+	server = NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		Hooks: &Hooks{
+			AfterRequest: func(req *Request, resp *Response, err error) error { return nil },
+			AfterStatus:  func(id string, resp *StatusResponse, err error) error { return nil },
+			AfterCancel:  func(id string, resp *CancellationResponse, err error) error { return nil },
+		},
+	})
+	proc.err = errors.New("database unavailable")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "has no response")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests/1234", nil))
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "has no response")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("DELETE", "/opengdpr_requests/1234", nil))
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "has no response")
+}
+
+func TestServerBeforeAfterOrder(t *testing.T) {
+	server, _ := newServer()
+	var order []string
+	tag := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { order = append(order, name) }
+	}
+	// Each call wraps those made before it
+	server.Before(tag("before one"))
+	server.Before(tag("before two"))
+	server.After(tag("after one"))
+	server.After(tag("after two"))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/discovery", nil))
+	assert.Equal(t, []string{"before two", "before one", "after one", "after two"}, order)
+}
+
+func TestServerBatchHooks(t *testing.T) {
+	var checked []string
+	hooks := &Hooks{
+		BeforeStatus: func(id string) error {
+			if id == "4321" {
+				return ErrorResponse{Code: http.StatusForbidden, Message: "forbidden"}
+			}
+			return nil
+		},
+		AfterStatus: func(id string, resp *StatusResponse, err error) error {
+			checked = append(checked, id)
+			return err
+		},
+	}
+	ids := []string{"4321", "missing", "1234"}
+	for _, proc := range []Processor{newMapProcessor(), batchProcessor{newMapProcessor()}} {
+		checked = nil
+		server := NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: proc, Hooks: hooks})
+		body, _ := json.Marshal(&BatchStatusRequest{SubjectRequestIds: ids})
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_statuses", bytes.NewBuffer(body)))
+		assert.Equal(t, 200, w.Code)
+		resp := &BatchStatusResponse{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+		assert.Equal(t, 403, resp.Statuses[0].Error.Code)
+		assert.Equal(t, 404, resp.Statuses[1].Error.Code)
+		assert.Equal(t, STATUS_PENDING, resp.Statuses[2].Status.RequestStatus)
+		assert.Equal(t, []string{"missing", "1234"}, checked)
+	}
+}
@@ -0,0 +1,254 @@
+package gdpr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// JWSAlgorithm is a JSON Web Signature
+// algorithm as defined in RFC 7518.
+type JWSAlgorithm string
+
+const (
+	JWS_RS256 = JWSAlgorithm("RS256")
+	JWS_PS256 = JWSAlgorithm("PS256")
+	JWS_ES256 = JWSAlgorithm("ES256")
+	JWS_EDDSA = JWSAlgorithm("EdDSA")
+)
+
+// JWSOptions configure a Signer or Verifier which
+// produces JWS compact serializations with a detached
+// payload (RFC 7515 Appendix F) in place of a raw
+// base64 encoded signature.
+type JWSOptions struct {
+	KeyOptions
+	// Signature algorithm, must match the key type.
+	Algorithm JWSAlgorithm
+	// Optional key ID set in the protected header.
+	// Verifiers reject signatures with any other
+	// key ID when set.
+	KeyID string
+}
+
+type jwsHeader struct {
+	Algorithm JWSAlgorithm `json:"alg"`
+	KeyID     string       `json:"kid,omitempty"`
+}
+
+var jwsEncoding = base64.RawURLEncoding
+
+// jwsSigningInput returns the bytes covered by a JWS
+// signature of the given protected header and payload.
+func jwsSigningInput(header string, payload []byte) []byte {
+	return []byte(header + "." + jwsEncoding.EncodeToString(payload))
+}
+
+// checkJWSKey ensures the algorithm can be used
+// with the given public key.
+func checkJWSKey(alg JWSAlgorithm, key interface{}) error {
+	var ok bool
+	switch alg {
+	case JWS_RS256, JWS_PS256:
+		_, ok = key.(*rsa.PublicKey)
+	case JWS_ES256:
+		var ecKey *ecdsa.PublicKey
+		ecKey, ok = key.(*ecdsa.PublicKey)
+		ok = ok && ecKey.Curve == elliptic.P256()
+	case JWS_EDDSA:
+		_, ok = key.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("unsupported jws algorithm: %s", alg)
+	}
+	if !ok {
+		return fmt.Errorf("key type cannot be used with %s", alg)
+	}
+	return nil
+}
+
+func MustNewJWSSigner(opts *JWSOptions) Signer {
+	signer, err := NewJWSSigner(opts)
+	if err != nil {
+		panic(err)
+	}
+	return signer
+}
+
+// NewJWSSigner creates a Signer producing detached
+// JWS signatures from a PKCS8 encoded private key.
+func NewJWSSigner(opts *JWSOptions) (Signer, error) {
+	parsed, err := loadPrivateKey(&opts.KeyOptions)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key")
+	}
+	if err := checkJWSKey(opts.Algorithm, key.Public()); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(jwsHeader{Algorithm: opts.Algorithm, KeyID: opts.KeyID})
+	if err != nil {
+		return nil, err
+	}
+	signer := &jwsSigner{
+		key:       key,
+		algorithm: opts.Algorithm,
+		header:    jwsEncoding.EncodeToString(raw),
+	}
+	if opts.Canonical {
+		return CanonicalSigner(signer), nil
+	}
+	return signer, nil
+}
+
+type jwsSigner struct {
+	key       crypto.Signer
+	algorithm JWSAlgorithm
+	header    string
+}
+
+func (s *jwsSigner) Sign(body []byte) (string, error) {
+	input := jwsSigningInput(s.header, body)
+	var (
+		signature []byte
+		err       error
+	)
+	switch s.algorithm {
+	case JWS_EDDSA:
+		signature, err = s.key.Sign(rand.Reader, input, crypto.Hash(0))
+	case JWS_PS256:
+		hashed := sha256.Sum256(input)
+		signature, err = s.key.Sign(rand.Reader, hashed[:],
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	case JWS_ES256:
+		hashed := sha256.Sum256(input)
+		signature, err = ecdsaSign(s.key.(*ecdsa.PrivateKey), hashed[:])
+	default:
+		hashed := sha256.Sum256(input)
+		signature, err = s.key.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", err
+	}
+	// Payload is omitted from the serialization
+	return s.header + ".." + jwsEncoding.EncodeToString(signature), nil
+}
+
+// ecdsaSign returns the fixed length R || S
+// signature required by RFC 7518 section 3.4.
+func ecdsaSign(key *ecdsa.PrivateKey, hashed []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, key, hashed)
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
+}
+
+func MustNewJWSVerifier(opts *JWSOptions) Verifier {
+	verifier, err := NewJWSVerifier(opts)
+	if err != nil {
+		panic(err)
+	}
+	return verifier
+}
+
+// NewJWSVerifier creates a Verifier of detached JWS
+// signatures from a PEM encoded x509 certificate.
+func NewJWSVerifier(opts *JWSOptions) (Verifier, error) {
+	cert, err := loadCertificate(&opts.KeyOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJWSKey(opts.Algorithm, cert.PublicKey); err != nil {
+		return nil, err
+	}
+	verifier := &jwsVerifier{
+		cert:      cert,
+		algorithm: opts.Algorithm,
+		keyID:     opts.KeyID,
+	}
+	if opts.Canonical {
+		return CanonicalVerifier(verifier), nil
+	}
+	return verifier, nil
+}
+
+type jwsVerifier struct {
+	cert      *x509.Certificate
+	algorithm JWSAlgorithm
+	keyID     string
+}
+
+func (v *jwsVerifier) Cert() *x509.Certificate {
+	return v.cert
+}
+
+func (v *jwsVerifier) Verify(body []byte, signature string) error {
+	if err := v.verify(body, signature); err != nil {
+		return ErrInvalidRequestSignature(signature, err)
+	}
+	return nil
+}
+
+func (v *jwsVerifier) verify(body []byte, signature string) error {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return fmt.Errorf("not a detached jws")
+	}
+	raw, err := jwsEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	header := jwsHeader{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return err
+	}
+	// Never allow the header to select the algorithm
+	if header.Algorithm != v.algorithm {
+		return fmt.Errorf("unexpected jws algorithm: %s", header.Algorithm)
+	}
+	if v.keyID != "" && header.KeyID != v.keyID {
+		return fmt.Errorf("unexpected jws key id: %s", header.KeyID)
+	}
+	decoded, err := jwsEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	input := jwsSigningInput(parts[0], body)
+	hashed := sha256.Sum256(input)
+	switch v.algorithm {
+	case JWS_RS256:
+		return rsa.VerifyPKCS1v15(v.cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, hashed[:], decoded)
+	case JWS_PS256:
+		return rsa.VerifyPSS(v.cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, hashed[:], decoded,
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case JWS_ES256:
+		if len(decoded) != 64 {
+			return fmt.Errorf("invalid ES256 signature length")
+		}
+		r, s := new(big.Int).SetBytes(decoded[:32]), new(big.Int).SetBytes(decoded[32:])
+		if !ecdsa.Verify(v.cert.PublicKey.(*ecdsa.PublicKey), hashed[:], r, s) {
+			return fmt.Errorf("ecdsa verification failed")
+		}
+	case JWS_EDDSA:
+		if !ed25519.Verify(v.cert.PublicKey.(ed25519.PublicKey), input, decoded) {
+			return fmt.Errorf("ed25519 verification failed")
+		}
+	}
+	return nil
+}
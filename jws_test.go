@@ -0,0 +1,109 @@
+package gdpr
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newKeyPair returns a PEM encoded PKCS8 private key
+// and self-signed certificate for key.
+func newKeyPair(t *testing.T, key crypto.Signer) [][]byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(t, err)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	return [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}),
+	}
+}
+
+func TestJWSSignerVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := map[JWSAlgorithm]crypto.Signer{
+		JWS_RS256: rsaKey,
+		JWS_PS256: rsaKey,
+		JWS_ES256: ecKey,
+		JWS_EDDSA: edKey,
+	}
+	body := []byte(`{"subject_request_id":"1234"}`)
+	for alg, key := range keys {
+		pair := newKeyPair(t, key)
+		signer := MustNewJWSSigner(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[0]}, Algorithm: alg, KeyID: "key-1"})
+		verifier := MustNewJWSVerifier(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[1]}, Algorithm: alg, KeyID: "key-1"})
+		sig, err := signer.Sign(body)
+		assert.NoError(t, err, string(alg))
+		assert.Contains(t, sig, "..")
+		assert.NoError(t, verifier.Verify(body, sig), string(alg))
+		assert.Error(t, verifier.Verify([]byte(`{"subject_request_id":"4321"}`), sig), string(alg))
+		// Key ID must match
+		other := MustNewJWSVerifier(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[1]}, Algorithm: alg, KeyID: "key-2"})
+		assert.Error(t, other.Verify(body, sig), string(alg))
+	}
+	// Algorithm must match the key type
+	_, err := NewJWSSigner(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: keyPairOne[0]}, Algorithm: JWS_ES256})
+	assert.Error(t, err)
+	// Header cannot downgrade the algorithm
+	pair := newKeyPair(t, rsaKey)
+	sig, _ := MustNewJWSSigner(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[0]}, Algorithm: JWS_RS256}).Sign(body)
+	verifier := MustNewJWSVerifier(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[1]}, Algorithm: JWS_PS256})
+	assert.Error(t, verifier.Verify(body, sig))
+}
+
+// Example from RFC 8037 Appendix A.4
+func TestJWSEd25519Vector(t *testing.T) {
+	seed, _ := base64.RawURLEncoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	pair := newKeyPair(t, ed25519.NewKeyFromSeed(seed))
+	payload := []byte("Example of Ed25519 signing")
+	expected := "eyJhbGciOiJFZERTQSJ9..hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+	sig, err := MustNewJWSSigner(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[0]}, Algorithm: JWS_EDDSA}).Sign(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, sig)
+	verifier := MustNewJWSVerifier(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[1]}, Algorithm: JWS_EDDSA})
+	assert.NoError(t, verifier.Verify(payload, expected))
+}
+
+func TestJWSServerClient(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pair := newKeyPair(t, ecKey)
+	server, _ := newServer()
+	server.signer = MustNewJWSSigner(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[0]}, Algorithm: JWS_ES256})
+	svr := httptest.NewServer(server)
+	defer svr.Close()
+	client := NewClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: MustNewJWSVerifier(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: pair[1]}, Algorithm: JWS_ES256}),
+	})
+	resp, err := client.Status(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", resp.SubjectRequestId)
+	// Responses signed with another key are rejected
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client = NewClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: MustNewJWSVerifier(&JWSOptions{KeyOptions: KeyOptions{KeyBytes: newKeyPair(t, otherKey)[1]}, Algorithm: JWS_ES256}),
+	})
+	_, err = client.Status(context.Background(), "1234")
+	assert.Error(t, err)
+}
@@ -0,0 +1,316 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LedgerEntry is the controller side record of
+// a Request sent to a single processor.
+type LedgerEntry struct {
+	ProcessorDomain  string
+	SubjectRequestId string
+	// Request sent to the processor without the values
+	// of it's subject identities, see LedgerRequest.
+	Request                *Request
+	Response               *Response
+	RequestStatus          RequestStatus
+	ExpectedCompletionTime time.Time
+	ResultsUrl             string
+	// Error message if the request could
+	// not be submitted to the processor.
+	Error       string
+	UpdatedTime time.Time
+}
+
+// Failed reports whether the request could not be
+// submitted to the processor.
+func (e *LedgerEntry) Failed() bool {
+	return e.Error != ""
+}
+
+// Overdue reports whether the processor has not finished
+// the request by its expected completion time.
+func (e *LedgerEntry) Overdue(now time.Time) bool {
+	return !e.Failed() && !e.RequestStatus.Finished() &&
+		!e.ExpectedCompletionTime.IsZero() && now.After(e.ExpectedCompletionTime)
+}
+
+// Ledger persists LedgerEntries keyed by processor
+// domain and subject_request_id.
+type Ledger interface {
+	// Put creates or replaces an entry.
+	Put(entry *LedgerEntry) error
+	// Update atomically applies fn to the entry for the
+	// given processor and request, fn is passed nil if no
+	// entry exists. fn modifies the entry in place and
+	// reports whether it changed, changed entries are
+	// stored. The result of fn is returned.
+	Update(domain, id string, fn func(entry *LedgerEntry) (bool, error)) (bool, error)
+	// Get returns the entry for the given processor
+	// and request or nil if none exists.
+	Get(domain, id string) (*LedgerEntry, error)
+	// List returns the entries of every processor
+	// the given request was sent to.
+	List(id string) ([]*LedgerEntry, error)
+	// Pending returns every entry which was submitted
+	// successfully but has not yet finished.
+	Pending() ([]*LedgerEntry, error)
+}
+
+// RecordStatus updates the ledger entry of a request with
+// a status reported by a processor either via a callback
+// or by polling and reports whether it moved the request
+// forward in its lifecycle, only such statuses should be
+// passed on to a Controller. Statuses for requests which
+// are not in the ledger are rejected, stale statuses and
+// changes to a finished request are ignored.
+func RecordStatus(ledger Ledger, domain string, status *StatusResponse) (bool, error) {
+	_, advanced, err := recordStatus(ledger, domain, status)
+	return advanced, err
+}
+
+// recordStatus is RecordStatus which also returns
+// the entry as it was before the status was recorded.
+func recordStatus(ledger Ledger, domain string, status *StatusResponse) (*LedgerEntry, bool, error) {
+	var (
+		previous LedgerEntry
+		advanced bool
+	)
+	_, err := ledger.Update(domain, status.SubjectRequestId, func(entry *LedgerEntry) (bool, error) {
+		if entry == nil {
+			return false, ErrNotFound(status.SubjectRequestId)
+		}
+		previous = *entry
+		advanced = entry.RequestStatus.Precedes(status.RequestStatus)
+		// A duplicate status may still refresh
+		// the expected completion time.
+		if !advanced && entry.RequestStatus != status.RequestStatus {
+			return false, nil
+		}
+		entry.RequestStatus = status.RequestStatus
+		if !status.ExpectedCompletionTime.IsZero() {
+			entry.ExpectedCompletionTime = status.ExpectedCompletionTime
+		}
+		if status.ResultsUrl != "" {
+			entry.ResultsUrl = status.ResultsUrl
+		}
+		entry.UpdatedTime = time.Now()
+		return true, nil
+	})
+	return &previous, advanced, err
+}
+
+// revertStatus restores previous when a status recorded
+// by recordStatus could not be delivered, unless the entry
+// has since moved on to another status.
+func revertStatus(ledger Ledger, previous *LedgerEntry, status RequestStatus) error {
+	_, err := ledger.Update(previous.ProcessorDomain, previous.SubjectRequestId, func(entry *LedgerEntry) (bool, error) {
+		if entry == nil || entry.RequestStatus != status {
+			return false, nil
+		}
+		*entry = *previous
+		return true, nil
+	})
+	return err
+}
+
+// LedgerRequest returns a copy of req suitable for a
+// LedgerEntry. The values of it's subject identities are
+// removed so personal data is not persisted by the ledger,
+// reconciliation only needs the request ID and status.
+func LedgerRequest(req *Request) *Request {
+	if req == nil {
+		return nil
+	}
+	redacted := *req
+	redacted.SubjectIdentities = make([]Identity, 0, len(req.SubjectIdentities))
+	for _, id := range req.SubjectIdentities {
+		redacted.SubjectIdentities = append(redacted.SubjectIdentities, Identity{Type: id.Type, Format: id.Format})
+	}
+	return &redacted
+}
+
+// callbackStatus converts a CallbackRequest into
+// the equivalent StatusResponse.
+func callbackStatus(cb *CallbackRequest) *StatusResponse {
+	return &StatusResponse{
+		ControllerId:           cb.ControllerId,
+		ExpectedCompletionTime: cb.ExpectedCompletionTime,
+		SubjectRequestId:       cb.SubjectRequestId,
+		RequestStatus:          cb.RequestStatus,
+		ResultsUrl:             cb.ResultsUrl,
+	}
+}
+
+// NewMemoryLedger returns a Ledger which keeps
+// all entries in memory.
+func NewMemoryLedger() Ledger {
+	return &memoryLedger{entries: map[string]map[string]*LedgerEntry{}}
+}
+
+type memoryLedger struct {
+	mu      sync.RWMutex
+	entries map[string]map[string]*LedgerEntry
+}
+
+func (m *memoryLedger) Put(entry *LedgerEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[entry.SubjectRequestId]; !ok {
+		m.entries[entry.SubjectRequestId] = map[string]*LedgerEntry{}
+	}
+	copied := *entry
+	m.entries[entry.SubjectRequestId][entry.ProcessorDomain] = &copied
+	return nil
+}
+
+func (m *memoryLedger) Update(domain, id string, fn func(entry *LedgerEntry) (bool, error)) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var copied *LedgerEntry
+	if entry, ok := m.entries[id][domain]; ok {
+		current := *entry
+		copied = &current
+	}
+	changed, err := fn(copied)
+	if err != nil || !changed || copied == nil {
+		return changed, err
+	}
+	m.entries[id][domain] = copied
+	return true, nil
+}
+
+func (m *memoryLedger) Get(domain, id string) (*LedgerEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[id][domain]
+	if !ok {
+		return nil, nil
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+func (m *memoryLedger) List(id string) ([]*LedgerEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []*LedgerEntry
+	for _, entry := range m.entries[id] {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (m *memoryLedger) Pending() ([]*LedgerEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []*LedgerEntry
+	for _, byDomain := range m.entries {
+		for _, entry := range byDomain {
+			if entry.Failed() || entry.RequestStatus.Finished() {
+				continue
+			}
+			copied := *entry
+			entries = append(entries, &copied)
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// sortEntries orders entries by request
+// and then by processor domain.
+func sortEntries(entries []*LedgerEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].SubjectRequestId != entries[j].SubjectRequestId {
+			return entries[i].SubjectRequestId < entries[j].SubjectRequestId
+		}
+		return entries[i].ProcessorDomain < entries[j].ProcessorDomain
+	})
+}
+
+// NewFileLedger returns a Ledger persisted as JSON to
+// the file at path so that it survives restarts. The
+// entire file is rewritten on each update which is
+// suitable for modest numbers of requests, larger
+// deployments should implement Ledger with a database.
+func NewFileLedger(path string) (Ledger, error) {
+	ledger := &fileLedger{
+		memoryLedger: memoryLedger{entries: map[string]map[string]*LedgerEntry{}},
+		path:         path,
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*LedgerEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		// Files written by earlier releases
+		// may hold identity values.
+		entry.Request = LedgerRequest(entry.Request)
+		ledger.memoryLedger.Put(entry)
+	}
+	return ledger, nil
+}
+
+type fileLedger struct {
+	memoryLedger
+	path string
+	// serializes writes to the file
+	wmu sync.Mutex
+}
+
+func (f *fileLedger) Put(entry *LedgerEntry) error {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	f.memoryLedger.Put(entry)
+	return f.save()
+}
+
+func (f *fileLedger) Update(domain, id string, fn func(entry *LedgerEntry) (bool, error)) (bool, error) {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	changed, err := f.memoryLedger.Update(domain, id, fn)
+	if err != nil || !changed {
+		return changed, err
+	}
+	return true, f.save()
+}
+
+// save writes every entry to the file,
+// the caller must hold wmu.
+func (f *fileLedger) save() error {
+	f.mu.RLock()
+	var entries []*LedgerEntry
+	for _, byDomain := range f.entries {
+		for _, entry := range byDomain {
+			entries = append(entries, entry)
+		}
+	}
+	sortEntries(entries)
+	raw, err := json.Marshal(entries)
+	f.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it
+	// so the ledger is never left truncated.
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,285 @@
+package gdpr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.json")
+	ledger, err := NewFileLedger(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ledger.Put(&LedgerEntry{
+		ProcessorDomain:  "one.com",
+		SubjectRequestId: "1234",
+		Request:          &Request{SubjectRequestId: "1234", SubjectRequestType: SUBJECT_ERASURE},
+		RequestStatus:    STATUS_PENDING,
+	}))
+	assert.NoError(t, ledger.Put(&LedgerEntry{
+		ProcessorDomain:  "two.com",
+		SubjectRequestId: "1234",
+		RequestStatus:    STATUS_COMPLETED,
+	}))
+	_, err = RecordStatus(ledger, "one.com", &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_IN_PROGRESS})
+	assert.NoError(t, err)
+	_, err = RecordStatus(ledger, "three.com", &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_IN_PROGRESS})
+	assert.Error(t, err)
+	// Entries survive being reloaded
+	ledger, err = NewFileLedger(path)
+	assert.NoError(t, err)
+	entries, err := ledger.List("1234")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, STATUS_IN_PROGRESS, entries[0].RequestStatus)
+	assert.Equal(t, SUBJECT_ERASURE, entries[0].Request.SubjectRequestType)
+	pending, err := ledger.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "one.com", pending[0].ProcessorDomain)
+}
+
+func TestServerLedgerCallback(t *testing.T) {
+	ledger := NewMemoryLedger()
+	controller := &mockController{}
+	server := NewServer(&ServerOptions{
+		Controller: controller,
+		Verifier:   NoopVerifier{},
+		Ledger:     ledger,
+	})
+	// Requests sent by the client are recorded
+	proc, _ := newServer()
+	svr := httptest.NewServer(proc)
+	defer svr.Close()
+	client := NewClient(&ClientOptions{
+		Endpoint:        svr.URL,
+		Verifier:        NoopVerifier{},
+		Ledger:          ledger,
+		ProcessorDomain: "processor.com",
+	})
+	_, err := client.Request(context.Background(), &Request{
+		SubjectRequestId:   "1234",
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	})
+	assert.NoError(t, err)
+	entry, err := ledger.Get("processor.com", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_PENDING, entry.RequestStatus)
+	// Identity values are not recorded
+	assert.Equal(t, []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}}, entry.Request.SubjectIdentities)
+	callback := func(domain, id string) int {
+		body, _ := json.Marshal(&CallbackRequest{SubjectRequestId: id, RequestStatus: STATUS_COMPLETED})
+		r := httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+		r.Header.Set("X-OpenGDPR-Processor-Domain", domain)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	// Callbacks for unknown requests or processors are rejected
+	assert.Equal(t, 404, callback("processor.com", "4321"))
+	assert.Equal(t, 404, callback("other.com", "1234"))
+	assert.Len(t, controller.callbacks, 0)
+	assert.Equal(t, 200, callback("processor.com", "1234"))
+	assert.Len(t, controller.callbacks, 1)
+	// Duplicate callbacks are not passed to the controller
+	assert.Equal(t, 200, callback("processor.com", "1234"))
+	assert.Len(t, controller.callbacks, 1)
+	entry, err = ledger.Get("processor.com", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, entry.RequestStatus)
+}
+
+func TestRecordStatusConcurrent(t *testing.T) {
+	ledger := NewMemoryLedger()
+	assert.NoError(t, ledger.Put(&LedgerEntry{ProcessorDomain: "one.com", SubjectRequestId: "1234", RequestStatus: STATUS_PENDING}))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		advanced int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(status RequestStatus) {
+			defer wg.Done()
+			ok, err := RecordStatus(ledger, "one.com", &StatusResponse{SubjectRequestId: "1234", RequestStatus: status})
+			assert.NoError(t, err)
+			if ok && status == STATUS_COMPLETED {
+				mu.Lock()
+				advanced++
+				mu.Unlock()
+			}
+		}([]RequestStatus{STATUS_IN_PROGRESS, STATUS_COMPLETED}[i%2])
+	}
+	wg.Wait()
+	// Only one completion is passed on and
+	// a slower in_progress never wins.
+	assert.Equal(t, 1, advanced)
+	entry, err := ledger.Get("one.com", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, entry.RequestStatus)
+	// Finished requests are not changed
+	ok, err := RecordStatus(ledger, "one.com", &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_CANCELLED})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	entry, err = ledger.Get("one.com", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, entry.RequestStatus)
+}
+
+type failingLedger struct {
+	Ledger
+}
+
+func (failingLedger) Put(*LedgerEntry) error { return errors.New("disk full") }
+
+func TestClientLedgerFailure(t *testing.T) {
+	proc, _ := newServer()
+	svr := httptest.NewServer(proc)
+	defer svr.Close()
+	client := NewClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: NoopVerifier{},
+		Ledger:   failingLedger{NewMemoryLedger()},
+	})
+	resp, err := client.Request(context.Background(), &Request{
+		SubjectRequestId:   "1234",
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	})
+	assert.Nil(t, resp)
+	assert.Error(t, err)
+}
+
+func TestFileLedgerRedacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.json")
+	// A ledger written with identity values
+	raw, _ := json.Marshal([]*LedgerEntry{&LedgerEntry{
+		ProcessorDomain:  "one.com",
+		RequestStatus:    STATUS_PENDING,
+		SubjectRequestId: "1234",
+		Request:          &Request{SubjectRequestType: SUBJECT_ERASURE, SubjectIdentities: []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}}},
+	}})
+	assert.NoError(t, ioutil.WriteFile(path, raw, 0600))
+	ledger, err := NewFileLedger(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ledger.Put(&LedgerEntry{ProcessorDomain: "two.com", SubjectRequestId: "1234", RequestStatus: STATUS_PENDING, Request: &Request{SubjectRequestType: SUBJECT_ERASURE}}))
+	raw, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "johndoe@example.com")
+}
+
+func TestReconciler(t *testing.T) {
+	ledger := NewMemoryLedger()
+	proc, mock := newServer()
+	svr := httptest.NewServer(proc)
+	defer svr.Close()
+	for _, id := range []string{"1234", "5678"} {
+		assert.NoError(t, ledger.Put(&LedgerEntry{
+			ProcessorDomain:  "processor.com",
+			SubjectRequestId: id,
+			RequestStatus:    STATUS_PENDING,
+			UpdatedTime:      time.Now().Add(-2 * time.Hour),
+		}))
+	}
+	// Recently updated entries are not polled
+	assert.NoError(t, ledger.Put(&LedgerEntry{
+		ProcessorDomain:  "processor.com",
+		SubjectRequestId: "recent",
+		RequestStatus:    STATUS_PENDING,
+		UpdatedTime:      time.Now(),
+	}))
+	controller := &mockController{}
+	reconciler := NewReconciler(&ReconcilerOptions{
+		Ledger:     ledger,
+		Controller: controller,
+		Clients: map[string]*Client{
+			"processor.com": NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}}),
+		},
+	})
+	mock.statusResponse = &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED}
+	changed, err := reconciler.Reconcile(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Len(t, controller.callbacks, 1)
+	pending, err := ledger.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+}
+
+func TestReconcilerOrchestrator(t *testing.T) {
+	o := NewOrchestrator(&OrchestratorOptions{})
+	proc, stop := newOrchestratedProcessor(t, o, "processor.com", SUBJECT_ERASURE)
+	defer stop()
+	_, err := o.Submit(context.Background(), &Request{
+		SubjectRequestId:   "1234",
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	})
+	assert.NoError(t, err)
+	ledger := NewMemoryLedger()
+	assert.NoError(t, ledger.Put(&LedgerEntry{
+		ProcessorDomain:  "processor.com",
+		SubjectRequestId: "1234",
+		RequestStatus:    STATUS_PENDING,
+		UpdatedTime:      time.Now().Add(-2 * time.Hour),
+	}))
+	reconciler := NewReconciler(&ReconcilerOptions{
+		Ledger:     ledger,
+		Controller: o,
+		Clients:    map[string]*Client{"processor.com": o.processors["processor.com"].client},
+	})
+	proc.statusResponse = &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED}
+	changed, err := reconciler.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	summary, err := o.Summary("1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, summary.RequestStatus)
+}
+
+func TestReconcilerControllerFailure(t *testing.T) {
+	ledger := NewMemoryLedger()
+	proc, mock := newServer()
+	svr := httptest.NewServer(proc)
+	defer svr.Close()
+	assert.NoError(t, ledger.Put(&LedgerEntry{
+		ProcessorDomain:  "processor.com",
+		SubjectRequestId: "1234",
+		RequestStatus:    STATUS_PENDING,
+		UpdatedTime:      time.Now().Add(-2 * time.Hour),
+	}))
+	controller := &mockController{failures: 1}
+	reconciler := NewReconciler(&ReconcilerOptions{
+		Ledger:     ledger,
+		Controller: controller,
+		Clients: map[string]*Client{
+			"processor.com": NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}}),
+		},
+	})
+	mock.statusResponse = &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED}
+	changed, err := reconciler.Reconcile(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, changed)
+	// The status is delivered on the next pass
+	changed, err = reconciler.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Len(t, controller.callbacks, 1)
+}
@@ -0,0 +1,230 @@
+package gdpr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is used when
+// RunnerOptions.ShutdownTimeout is unset.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Worker is a long running background task such as a
+// request processing loop or a Reconciler. Run should
+// return once the context is done.
+type Worker interface {
+	Run(ctx context.Context) error
+}
+
+// WorkerFunc adapts an ordinary function to a Worker.
+type WorkerFunc func(ctx context.Context) error
+
+func (fn WorkerFunc) Run(ctx context.Context) error { return fn(ctx) }
+
+// Persister may be implemented by a Worker to save any
+// work it has not finished. It is called once the Worker
+// returns if it was interrupted, i.e. it's context was
+// cancelled by shutdown, Workers which return on their
+// own are not persisted.
+type Persister interface {
+	Persist() error
+}
+
+// RunnerOptions configure a Runner.
+type RunnerOptions struct {
+	// Handler serving requests, typically a Server.
+	Handler http.Handler
+	// Address to listen on when Listener is nil.
+	Addr string
+	// Optional Listener to serve requests from.
+	Listener net.Listener
+	// Workers run in the background until shutdown.
+	Workers []Worker
+	// Maximum time to wait for in-flight requests
+	// and background work during shutdown before
+	// the contexts of tasks are cancelled, defaults
+	// to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// Optional function called with errors
+	// returned by Workers and tasks.
+	OnError func(error)
+}
+
+// Runner owns the listener of a Server and its background
+// work. On shutdown it stops accepting new requests, waits
+// for in-flight requests, Workers and tasks started with Go
+// and persists the Workers it interrupted as they stop.
+type Runner struct {
+	opts   RunnerOptions
+	server *http.Server
+	// stop is cancelled when shutdown begins.
+	stop       context.Context
+	cancelStop context.CancelFunc
+	// abort is cancelled when the shutdown deadline passes.
+	abort       context.Context
+	cancelAbort context.CancelFunc
+	mu          sync.Mutex
+	closed      bool
+	// first error persisting a task
+	persistErr error
+	wg         sync.WaitGroup
+	once       sync.Once
+	err        error
+}
+
+// NewRunner returns a new Runner.
+func NewRunner(opts *RunnerOptions) *Runner {
+	runner := &Runner{
+		opts:   *opts,
+		server: &http.Server{Handler: opts.Handler},
+	}
+	if runner.opts.ShutdownTimeout == 0 {
+		runner.opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	runner.stop, runner.cancelStop = context.WithCancel(context.Background())
+	runner.abort, runner.cancelAbort = context.WithCancel(context.Background())
+	return runner
+}
+
+// Go runs fn in the background, such as sending
+// callbacks for a request. Shutdown waits for fn to
+// return, its context is cancelled if it is still
+// running when the shutdown deadline passes. An error
+// is returned if the Runner has already shut down.
+func (r *Runner) Go(fn func(ctx context.Context) error) error {
+	return r.spawn(r.abort, fn)
+}
+
+// GoWorker runs w in the background like Go. If w implements
+// Persister and is interrupted by shutdown, Persist is called
+// once it returns so it can save it's unfinished work.
+func (r *Runner) GoWorker(w Worker) error {
+	return r.spawn(r.abort, r.persisting(w))
+}
+
+// persisting runs w and persists it if interrupted.
+func (r *Runner) persisting(w Worker) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		err := w.Run(ctx)
+		if persister, ok := w.(Persister); ok && ctx.Err() != nil {
+			if persistErr := persister.Persist(); persistErr != nil {
+				r.mu.Lock()
+				if r.persistErr == nil {
+					r.persistErr = persistErr
+				}
+				r.mu.Unlock()
+			}
+		}
+		return err
+	}
+}
+
+// spawn runs fn with ctx unless the Runner is shut down.
+func (r *Runner) spawn(ctx context.Context, fn func(ctx context.Context) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return fmt.Errorf("runner is shut down")
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.report(fn(ctx))
+	}()
+	return nil
+}
+
+// Run serves requests and runs each Worker until the
+// context is done or the listener fails and then
+// shuts the Runner down.
+func (r *Runner) Run(ctx context.Context) error {
+	listener := r.opts.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", r.opts.Addr)
+		if err != nil {
+			return err
+		}
+	}
+	for _, worker := range r.opts.Workers {
+		if err := r.spawn(r.stop, r.persisting(worker)); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- r.server.Serve(listener) }()
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+	// Serve returns ErrServerClosed once
+	// Shutdown has been called elsewhere
+	if shutdownErr := r.Shutdown(); err == nil || errors.Is(err, http.ErrServerClosed) {
+		err = shutdownErr
+	}
+	return err
+}
+
+// Shutdown stops accepting new requests and waits up to
+// ShutdownTimeout for in-flight requests, Workers and tasks
+// to finish. Tasks still running are then cancelled. Each
+// interrupted Persister is persisted once it has returned,
+// see Persister. It is safe to call more than once.
+func (r *Runner) Shutdown() error {
+	r.once.Do(func() { r.err = r.shutdown() })
+	return r.err
+}
+
+func (r *Runner) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.ShutdownTimeout)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		r.cancelAbort()
+	}()
+	// In-flight requests may still start tasks
+	// so they are drained before anything else.
+	err := r.server.Shutdown(ctx)
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.cancelStop()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("timed out after %s waiting for background work", r.opts.ShutdownTimeout)
+		}
+		// Interrupted work must have
+		// stopped before it is persisted
+		r.cancelAbort()
+		<-done
+	}
+	r.mu.Lock()
+	if err == nil {
+		err = r.persistErr
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// report passes errors other than
+// cancellation to OnError if set.
+func (r *Runner) report(err error) {
+	if err == nil || errors.Is(err, context.Canceled) || r.opts.OnError == nil {
+		return
+	}
+	r.opts.OnError(err)
+}
@@ -0,0 +1,211 @@
+package gdpr
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type persistingWorker struct {
+	stopped   chan struct{}
+	persisted bool
+}
+
+func (w *persistingWorker) Run(ctx context.Context) error {
+	<-ctx.Done()
+	close(w.stopped)
+	return ctx.Err()
+}
+
+func (w *persistingWorker) Persist() error {
+	w.persisted = true
+	return nil
+}
+
+func TestRunnerDrainsInFlight(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	started, release := make(chan struct{}), make(chan struct{})
+	worker := &persistingWorker{stopped: make(chan struct{})}
+	var runner *Runner
+	taskDone := false
+	runner = NewRunner(&RunnerOptions{
+		Listener: listener,
+		Workers:  []Worker{worker},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			// Tasks may be started while draining
+			assert.NoError(t, runner.Go(func(ctx context.Context) error {
+				time.Sleep(10 * time.Millisecond)
+				taskDone = true
+				return nil
+			}))
+			w.Write([]byte("done"))
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(ctx) }()
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		assert.NoError(t, err)
+		raw, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(raw)
+	}()
+	<-started
+	cancel()
+	// New connections are refused once shutdown begins
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if i == 100 {
+			t.Fatal("listener still accepting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	assert.Equal(t, "done", <-body)
+	assert.NoError(t, <-runErr)
+	assert.True(t, taskDone)
+	assert.True(t, worker.persisted)
+	<-worker.stopped
+	assert.Error(t, runner.Go(func(ctx context.Context) error { return nil }))
+}
+
+func TestRunnerShutdownTimeout(t *testing.T) {
+	var errs []error
+	runner := NewRunner(&RunnerOptions{
+		ShutdownTimeout: 20 * time.Millisecond,
+		OnError:         func(err error) { errs = append(errs, err) },
+	})
+	aborted := make(chan struct{})
+	assert.NoError(t, runner.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		close(aborted)
+		return ctx.Err()
+	}))
+	assert.Error(t, runner.Shutdown())
+	<-aborted
+	assert.Error(t, runner.Shutdown())
+	assert.Empty(t, errs)
+}
+
+// slowWorker takes a while to stop and
+// records whether it had stopped when
+// it was persisted.
+type slowWorker struct {
+	stopped          int32
+	persistedStopped bool
+}
+
+func (w *slowWorker) Run(ctx context.Context) error {
+	<-ctx.Done()
+	time.Sleep(50 * time.Millisecond)
+	atomic.StoreInt32(&w.stopped, 1)
+	return ctx.Err()
+}
+
+func (w *slowWorker) Persist() error {
+	w.persistedStopped = atomic.LoadInt32(&w.stopped) == 1
+	return nil
+}
+
+func TestRunnerPersistAfterStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	worker, task := &slowWorker{}, &slowWorker{}
+	runner := NewRunner(&RunnerOptions{
+		Listener:        listener,
+		Handler:         http.NotFoundHandler(),
+		ShutdownTimeout: 10 * time.Millisecond,
+		Workers:         []Worker{worker},
+	})
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background()) }()
+	waitServing(t, listener)
+	// Tasks interrupted by the deadline are persisted
+	assert.NoError(t, runner.GoWorker(task))
+	assert.Error(t, runner.Shutdown())
+	assert.Error(t, <-runErr)
+	assert.True(t, worker.persistedStopped)
+	assert.True(t, task.persistedStopped)
+}
+
+// finishedWorker returns without being interrupted.
+type finishedWorker struct {
+	finished  chan struct{}
+	persisted bool
+}
+
+func (w *finishedWorker) Run(ctx context.Context) error {
+	close(w.finished)
+	return nil
+}
+
+func (w *finishedWorker) Persist() error {
+	w.persisted = true
+	return nil
+}
+
+func TestRunnerPersistInterrupted(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	worker := &finishedWorker{finished: make(chan struct{})}
+	task := &finishedWorker{finished: make(chan struct{})}
+	runner := NewRunner(&RunnerOptions{
+		Listener: listener,
+		Handler:  http.NotFoundHandler(),
+		Workers:  []Worker{worker},
+	})
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background()) }()
+	waitServing(t, listener)
+	assert.NoError(t, runner.GoWorker(task))
+	<-worker.finished
+	<-task.finished
+	// Workers and tasks which finish on their own
+	// have nothing to persist
+	assert.NoError(t, runner.Shutdown())
+	assert.NoError(t, <-runErr)
+	assert.False(t, worker.persisted)
+	assert.False(t, task.persisted)
+}
+
+func TestRunnerExternalShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	runner := NewRunner(&RunnerOptions{Listener: listener, Handler: http.NotFoundHandler()})
+	runErr := make(chan error)
+	go func() { runErr <- runner.Run(context.Background()) }()
+	waitServing(t, listener)
+	// Shutdown called elsewhere does not fail Run
+	assert.NoError(t, runner.Shutdown())
+	assert.NoError(t, <-runErr)
+}
+
+// waitServing waits for the listener to serve requests.
+func waitServing(t *testing.T, listener net.Listener) {
+	for i := 0; ; i++ {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if i == 100 {
+			t.Fatal("listener not serving")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
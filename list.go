@@ -0,0 +1,240 @@
+package gdpr
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListLimit is the number of requests
+	// returned in a page when no limit is given.
+	DefaultListLimit = 100
+	// MaxListLimit is the largest number of
+	// requests returned in a single page.
+	MaxListLimit = 1000
+)
+
+// ListOptions filter and paginate
+// the requests returned by a Lister.
+type ListOptions struct {
+	// Optional status of the requests.
+	RequestStatus RequestStatus
+	// Optional type of the requests.
+	SubjectRequestType SubjectType
+	// Optional range of submitted times.
+	SubmittedAfter  time.Time
+	SubmittedBefore time.Time
+	// Optional range of expected completion times.
+	ExpectedAfter  time.Time
+	ExpectedBefore time.Time
+	// Opaque cursor returned by the
+	// previous page if any.
+	Cursor string
+	// Maximum number of requests in the page,
+	// defaults to DefaultListLimit.
+	Limit int
+}
+
+// Match reports whether a request satisfies the filters.
+func (o *ListOptions) Match(req *ListedRequest) bool {
+	switch {
+	case o.RequestStatus != "" && req.RequestStatus != o.RequestStatus:
+		return false
+	case o.SubjectRequestType != "" && req.SubjectRequestType != o.SubjectRequestType:
+		return false
+	case !o.SubmittedAfter.IsZero() && !req.SubmittedTime.After(o.SubmittedAfter):
+		return false
+	case !o.SubmittedBefore.IsZero() && !req.SubmittedTime.Before(o.SubmittedBefore):
+		return false
+	case !o.ExpectedAfter.IsZero() && !req.ExpectedCompletionTime.After(o.ExpectedAfter):
+		return false
+	case !o.ExpectedBefore.IsZero() && !req.ExpectedCompletionTime.Before(o.ExpectedBefore):
+		return false
+	}
+	return true
+}
+
+// Values encodes the options as query parameters.
+func (o *ListOptions) Values() url.Values {
+	values := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	setTime := func(key string, t time.Time) {
+		if !t.IsZero() {
+			values.Set(key, t.Format(time.RFC3339Nano))
+		}
+	}
+	set("request_status", string(o.RequestStatus))
+	set("subject_request_type", string(o.SubjectRequestType))
+	setTime("submitted_after", o.SubmittedAfter)
+	setTime("submitted_before", o.SubmittedBefore)
+	setTime("expected_after", o.ExpectedAfter)
+	setTime("expected_before", o.ExpectedBefore)
+	set("cursor", o.Cursor)
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return values
+}
+
+// ParseListOptions decodes ListOptions from query
+// parameters, see ListOptions.Values.
+func ParseListOptions(values url.Values) (*ListOptions, error) {
+	opts := &ListOptions{Cursor: values.Get("cursor"), Limit: DefaultListLimit}
+	if status := values.Get("request_status"); status != "" {
+		if _, ok := RequestStatusMap[status]; !ok {
+			return nil, ErrInvalidParameter("request_status", status)
+		}
+		opts.RequestStatus = RequestStatus(status)
+	}
+	if st := values.Get("subject_request_type"); st != "" {
+		if !SubjectType(st).Valid() {
+			return nil, ErrInvalidParameter("subject_request_type", st)
+		}
+		opts.SubjectRequestType = SubjectType(st)
+	}
+	times := map[string]*time.Time{
+		"submitted_after":  &opts.SubmittedAfter,
+		"submitted_before": &opts.SubmittedBefore,
+		"expected_after":   &opts.ExpectedAfter,
+		"expected_before":  &opts.ExpectedBefore,
+	}
+	for key, t := range times {
+		value := values.Get(key)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, ErrInvalidParameter(key, value)
+		}
+		*t = parsed
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, ErrInvalidParameter("limit", limit)
+		}
+		opts.Limit = n
+	}
+	if opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+	return opts, nil
+}
+
+// ListedRequest summarizes a single
+// request known to a processor.
+type ListedRequest struct {
+	SubjectRequestId       string        `json:"subject_request_id"`
+	SubjectRequestType     SubjectType   `json:"subject_request_type"`
+	RequestStatus          RequestStatus `json:"request_status"`
+	SubmittedTime          time.Time     `json:"submitted_time"`
+	ExpectedCompletionTime time.Time     `json:"expected_completion_time"`
+}
+
+// ListResponse is a single page of requests.
+type ListResponse struct {
+	Requests []*ListedRequest `json:"requests"`
+	// Cursor of the next page, empty
+	// if this is the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Lister is an optional interface a Processor may implement
+// to allow operators to search the requests it holds. When
+// the Processor is a Lister and ServerOptions.Authenticator
+// is set GET /opengdpr_requests is served. Requests rejected
+// by the status Hooks are omitted from each page.
+type Lister interface {
+	List(opts *ListOptions) (*ListResponse, error)
+}
+
+// listCursor encodes the position of a request in the
+// order used by PageRequests. The submitted time is kept as
+// seconds and nanoseconds since it may be outside the range
+// of UnixNano, as the zero time is.
+func listCursor(req *ListedRequest) string {
+	raw := fmt.Sprintf("%d.%09d:%s", req.SubmittedTime.Unix(), req.SubmittedTime.Nanosecond(), req.SubjectRequestId)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseListCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidParameter("cursor", cursor)
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidParameter("cursor", cursor)
+	}
+	stamp := strings.SplitN(parts[0], ".", 2)
+	if len(stamp) != 2 {
+		return time.Time{}, "", ErrInvalidParameter("cursor", cursor)
+	}
+	sec, err := strconv.ParseInt(stamp[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidParameter("cursor", cursor)
+	}
+	nsec, err := strconv.ParseInt(stamp[1], 10, 64)
+	if err != nil || nsec < 0 || nsec >= int64(time.Second) {
+		return time.Time{}, "", ErrInvalidParameter("cursor", cursor)
+	}
+	return time.Unix(sec, nsec), parts[1], nil
+}
+
+// PageRequests filters and paginates requests held in memory
+// and may be used to implement Lister. Requests are ordered
+// by submitted time and then subject_request_id.
+func PageRequests(requests []*ListedRequest, opts *ListOptions) (*ListResponse, error) {
+	var (
+		afterTime time.Time
+		afterId   string
+	)
+	if opts.Cursor != "" {
+		var err error
+		afterTime, afterId, err = parseListCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	var matched []*ListedRequest
+	for _, req := range requests {
+		if opts.Match(req) {
+			matched = append(matched, req)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].SubmittedTime.Equal(matched[j].SubmittedTime) {
+			return matched[i].SubmittedTime.Before(matched[j].SubmittedTime)
+		}
+		return matched[i].SubjectRequestId < matched[j].SubjectRequestId
+	})
+	resp := &ListResponse{Requests: []*ListedRequest{}}
+	for _, req := range matched {
+		if opts.Cursor != "" {
+			if req.SubmittedTime.Before(afterTime) ||
+				req.SubmittedTime.Equal(afterTime) && req.SubjectRequestId <= afterId {
+				continue
+			}
+		}
+		if len(resp.Requests) == limit {
+			resp.NextCursor = listCursor(resp.Requests[limit-1])
+			break
+		}
+		resp.Requests = append(resp.Requests, req)
+	}
+	return resp, nil
+}
@@ -0,0 +1,161 @@
+package gdpr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockLister struct {
+	mockProcessor
+	requests []*ListedRequest
+}
+
+func (m mockLister) List(opts *ListOptions) (*ListResponse, error) {
+	return PageRequests(m.requests, opts)
+}
+
+func TestPageRequests(t *testing.T) {
+	start := time.Date(2018, 10, 2, 15, 0, 0, 0, time.UTC)
+	var requests []*ListedRequest
+	for i := 0; i < 5; i++ {
+		status := STATUS_PENDING
+		if i%2 == 0 {
+			status = STATUS_COMPLETED
+		}
+		requests = append(requests, &ListedRequest{
+			SubjectRequestId:   fmt.Sprintf("%d", i),
+			SubjectRequestType: SUBJECT_ERASURE,
+			RequestStatus:      status,
+			// Two requests submitted at the same time
+			SubmittedTime: start.Add(time.Duration(i/2) * time.Hour),
+		})
+	}
+	resp, err := PageRequests(requests, &ListOptions{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Requests, 2)
+	assert.Equal(t, "0", resp.Requests[0].SubjectRequestId)
+	assert.Equal(t, "1", resp.Requests[1].SubjectRequestId)
+	resp, err = PageRequests(requests, &ListOptions{Limit: 2, Cursor: resp.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", resp.Requests[0].SubjectRequestId)
+	assert.Equal(t, "3", resp.Requests[1].SubjectRequestId)
+	resp, err = PageRequests(requests, &ListOptions{Limit: 2, Cursor: resp.NextCursor})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Requests, 1)
+	assert.Empty(t, resp.NextCursor)
+	resp, err = PageRequests(requests, &ListOptions{RequestStatus: STATUS_COMPLETED, SubmittedAfter: start})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Requests, 2)
+	_, err = PageRequests(requests, &ListOptions{Cursor: "!!"})
+	assert.Error(t, err)
+}
+
+func TestPageRequestsZeroTime(t *testing.T) {
+	var requests []*ListedRequest
+	for i := 0; i < 5; i++ {
+		requests = append(requests, &ListedRequest{
+			SubjectRequestId:   fmt.Sprintf("%d", i),
+			SubjectRequestType: SUBJECT_ERASURE,
+			RequestStatus:      STATUS_PENDING,
+		})
+	}
+	var ids []string
+	opts := &ListOptions{Limit: 2}
+	for page := 0; page < 3; page++ {
+		resp, err := PageRequests(requests, opts)
+		assert.NoError(t, err)
+		for _, req := range resp.Requests {
+			ids = append(ids, req.SubjectRequestId)
+		}
+		opts.Cursor = resp.NextCursor
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, ids)
+	assert.Empty(t, opts.Cursor)
+}
+
+func TestParseListOptions(t *testing.T) {
+	opts := &ListOptions{
+		RequestStatus:      STATUS_IN_PROGRESS,
+		SubjectRequestType: SUBJECT_ACCESS,
+		ExpectedBefore:     time.Date(2018, 10, 2, 15, 0, 0, 0, time.UTC),
+		Cursor:             "abc",
+		Limit:              10,
+	}
+	parsed, err := ParseListOptions(opts.Values())
+	assert.NoError(t, err)
+	assert.Equal(t, opts, parsed)
+	parsed, err = ParseListOptions(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultListLimit, parsed.Limit)
+	for _, query := range []string{"request_status=done", "limit=0", "submitted_after=yesterday", "subject_request_type=nope"} {
+		values, _ := url.ParseQuery(query)
+		_, err = ParseListOptions(values)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	proc := &mockLister{requests: []*ListedRequest{
+		&ListedRequest{SubjectRequestId: "1234", SubjectRequestType: SUBJECT_ERASURE, RequestStatus: STATUS_PENDING},
+		&ListedRequest{SubjectRequestId: "4321", SubjectRequestType: SUBJECT_ACCESS, RequestStatus: STATUS_PENDING},
+	}}
+	server := NewServer(&ServerOptions{
+		Signer:    NoopSigner{},
+		Processor: proc,
+		Authenticator: AuthenticatorFunc(func(r *http.Request) (string, error) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				return "", ErrUnauthorized("bad token")
+			}
+			return "operator", nil
+		}),
+	})
+	svr := httptest.NewServer(server)
+	defer svr.Close()
+	client := NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}})
+	_, err := client.List(context.Background(), nil)
+	assert.Error(t, err)
+	assert.Equal(t, 401, err.(*ErrorResponse).Code)
+	client = NewClient(&ClientOptions{
+		Endpoint: svr.URL,
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(r *http.Request) (*http.Response, error) {
+			r.Header.Set("Authorization", "Bearer secret")
+			return http.DefaultClient.Do(r)
+		}),
+	})
+	resp, err := client.List(context.Background(), &ListOptions{SubjectRequestType: SUBJECT_ACCESS})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Requests, 1)
+	assert.Equal(t, "4321", resp.Requests[0].SubjectRequestId)
+	_, err = client.List(context.Background(), &ListOptions{Cursor: "!!"})
+	assert.Equal(t, 400, err.(*ErrorResponse).Code)
+	// Requests rejected by the status hooks are omitted
+	server = NewServer(&ServerOptions{
+		Signer:        NoopSigner{},
+		Processor:     proc,
+		Authenticator: AuthenticatorFunc(func(r *http.Request) (string, error) { return "operator", nil }),
+		Hooks: &Hooks{BeforeStatus: func(id string) error {
+			if id == "1234" {
+				return ErrNotFound(id)
+			}
+			return nil
+		}},
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), `"1234"`)
+	assert.Contains(t, w.Body.String(), `"4321"`)
+	// Listing is not served without an Authenticator
+	server = NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: proc})
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests", nil))
+	assert.Equal(t, 405, w.Code)
+}
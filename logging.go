@@ -0,0 +1,144 @@
+package gdpr
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Events emitted to a Logger.
+const (
+	EventRequestReceived   = "request_received"
+	EventRequestSent       = "request_sent"
+	EventValidationFailed  = "validation_failed"
+	EventSignatureMismatch = "signature_mismatch"
+	EventCallbackReceived  = "callback_received"
+	EventCallbackAttempt   = "callback_attempt"
+	EventError             = "error"
+)
+
+// Logger receives structured events from Server, Client
+// and Callback. Fields use consistent names such as
+// subject_request_id and processor_domain. Identity
+// values are always redacted before being logged,
+// including from the messages of errors logged in the
+// same event.
+type Logger interface {
+	Log(event string, fields map[string]interface{})
+}
+
+// LoggerFunc adapts an ordinary function to a Logger.
+type LoggerFunc func(event string, fields map[string]interface{})
+
+func (fn LoggerFunc) Log(event string, fields map[string]interface{}) { fn(event, fields) }
+
+// NewStdLogger returns a Logger writing each event to
+// logger as a single line of key=value pairs.
+func NewStdLogger(logger *log.Logger) Logger {
+	return LoggerFunc(func(event string, fields map[string]interface{}) {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := []string{"event=" + event}
+		for _, key := range keys {
+			value := fmt.Sprint(fields[key])
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+		logger.Println(strings.Join(pairs, " "))
+	})
+}
+
+// redactIdentity describes an identity without its value.
+func redactIdentity(id Identity) string {
+	return string(id.Type) + "/" + string(id.Format)
+}
+
+// redactError describes an error with the given identity
+// values removed from it's message. Panics are described by
+// the type of their value alone as the identities they may
+// contain are unknown.
+func redactError(err error, values []string) string {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return fmt.Sprintf("panic: %T", panicErr.Value)
+	}
+	msg := err.Error()
+	for _, value := range values {
+		if value != "" {
+			msg = strings.Replace(msg, value, "[redacted]", -1)
+		}
+	}
+	return msg
+}
+
+// identityValues returns the values of every
+// identity in fields.
+func identityValues(fields map[string]interface{}) []string {
+	var ids []Identity
+	for _, value := range fields {
+		switch v := value.(type) {
+		case Identity:
+			ids = append(ids, v)
+		case *Identity:
+			ids = append(ids, *v)
+		case []Identity:
+			ids = append(ids, v...)
+		case Request:
+			ids = append(ids, v.SubjectIdentities...)
+		case *Request:
+			if v != nil {
+				ids = append(ids, v.SubjectIdentities...)
+			}
+		}
+	}
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id.Value)
+	}
+	return values
+}
+
+// redact replaces identities and errors in fields
+// with descriptions which omit identity values.
+func redact(fields map[string]interface{}) {
+	values := identityValues(fields)
+	for key, value := range fields {
+		switch v := value.(type) {
+		case Identity:
+			fields[key] = redactIdentity(v)
+		case *Identity:
+			fields[key] = redactIdentity(*v)
+		case []Identity:
+			redacted := make([]string, 0, len(v))
+			for _, id := range v {
+				redacted = append(redacted, redactIdentity(id))
+			}
+			fields[key] = redacted
+		case *Request, Request:
+			// Requests contain identities
+			delete(fields, key)
+		case error:
+			fields[key] = redactError(v, values)
+		}
+	}
+}
+
+// logEvent sends an event to the Logger if set.
+func logEvent(logger Logger, event string, fields map[string]interface{}) {
+	if logger == nil {
+		return
+	}
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	redact(fields)
+	logger.Log(event, fields)
+}
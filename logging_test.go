@@ -0,0 +1,109 @@
+package gdpr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type logEntry struct {
+	event  string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	entries []logEntry
+}
+
+func (l *recordingLogger) Log(event string, fields map[string]interface{}) {
+	l.entries = append(l.entries, logEntry{event, fields})
+}
+
+func (l *recordingLogger) events() []string {
+	events := []string{}
+	for _, entry := range l.entries {
+		events = append(events, entry.event)
+	}
+	return events
+}
+
+func TestServerLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    &mockProcessor{response: &Response{SubjectRequestId: "1234"}},
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		Logger:       logger,
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, []string{EventRequestReceived}, logger.events())
+	fields := logger.entries[0].fields
+	assert.Equal(t, "a7551968-d5d6-44b2-9831-815ac9017798", fields["subject_request_id"])
+	assert.Equal(t, []string{"email/raw"}, fields["subject_identities"])
+	// Unsupported subject type
+	body := bytes.Replace(mockRequestBody, []byte(`"erasure"`), []byte(`"access"`), 1)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(body)))
+	assert.Equal(t, 501, w.Code)
+	assert.Equal(t, []string{EventRequestReceived, EventRequestReceived, EventValidationFailed}, logger.events())
+}
+
+func TestCallbackLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	ctrlSvr := httptest.NewServer(NewServer(&ServerOptions{Controller: &mockController{}, Verifier: NoopVerifier{}}))
+	defer ctrlSvr.Close()
+	cb := &CallbackRequest{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED, StatusCallbackUrl: ctrlSvr.URL + "/opengdpr_callbacks"}
+	assert.NoError(t, Callback(cb, &CallbackOptions{
+		MaxAttempts:     1,
+		ProcessorDomain: "processor.com",
+		Signer:          NoopSigner{},
+		Logger:          logger,
+	}))
+	assert.Equal(t, []string{EventCallbackAttempt}, logger.events())
+	fields := logger.entries[0].fields
+	assert.Equal(t, "1234", fields["subject_request_id"])
+	assert.Equal(t, "processor.com", fields["processor_domain"])
+	assert.Equal(t, 1, fields["attempt"])
+	assert.Equal(t, 200, fields["status_code"])
+}
+
+func TestStdLoggerRedacts(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	logger := NewStdLogger(log.New(buf, "", 0))
+	logEvent(logger, EventRequestReceived, map[string]interface{}{
+		"subject_request_id": "1234",
+		"identity":           Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"},
+		"request":            &Request{SubjectIdentities: []Identity{Identity{Value: "johndoe@example.com"}}},
+		"error":              "bad request",
+	})
+	line := buf.String()
+	assert.False(t, strings.Contains(line, "johndoe@example.com"))
+	assert.Equal(t, "event=request_received error=\"bad request\" identity=email/raw subject_request_id=1234\n", line)
+}
+
+func TestLoggerRedactsErrors(t *testing.T) {
+	logger := &recordingLogger{}
+	logEvent(logger, EventError, map[string]interface{}{
+		"panic":      &PanicError{Value: "bad identity johndoe@example.com"},
+		"validation": ErrorResponse{Code: 400, Message: "bad identity johndoe@example.com"},
+		"remote":     fmt.Errorf("request failed: %w", ErrorResponse{Code: 500, Message: "janedoe@example.com"}),
+		"other":      errors.New("could not erase johndoe@example.com: timeout"),
+		"identity":   &Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"},
+		"request":    &Request{SubjectIdentities: []Identity{Identity{Value: "janedoe@example.com"}}},
+	})
+	fields := logger.entries[0].fields
+	assert.Equal(t, "panic: string", fields["panic"])
+	assert.Equal(t, "code=400,message=bad identity [redacted],nested_errors=0", fields["validation"])
+	assert.Equal(t, "request failed: code=500,message=[redacted],nested_errors=0", fields["remote"])
+	assert.Equal(t, "could not erase [redacted]: timeout", fields["other"])
+	assert.Equal(t, "email/raw", fields["identity"])
+}
@@ -0,0 +1,266 @@
+package gdpr
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Names of the metrics recorded by Server, Client and Callback.
+const (
+	// Requests served labeled by route, method and code.
+	MetricServerRequests = "gdpr_server_requests_total"
+	// Latency of requests served labeled by route and method.
+	MetricServerDuration = "gdpr_server_request_duration_seconds"
+	// Requests accepted by a processor labeled by subject_type.
+	MetricSubmissions = "gdpr_submissions_total"
+	// Signatures which failed verification labeled by
+	// side, either server or client.
+	MetricVerificationFailures = "gdpr_signature_verification_failures_total"
+	// Calls made by a Client labeled by route, method and code.
+	MetricClientRequests = "gdpr_client_requests_total"
+	// Latency of calls made by a Client labeled by route and method.
+	MetricClientDuration = "gdpr_client_request_duration_seconds"
+	// Callbacks sent labeled by result, success or failure.
+	MetricCallbacks = "gdpr_callbacks_total"
+	// Latency of sending callbacks including retries.
+	MetricCallbackDuration = "gdpr_callback_duration_seconds"
+)
+
+// Metrics records counters and latencies. Implementations
+// must be safe for concurrent use, see PrometheusMetrics.
+type Metrics interface {
+	// IncCounter increments the counter
+	// with the given name and labels.
+	IncCounter(name string, labels map[string]string)
+	// ObserveDuration records a duration in the
+	// histogram with the given name and labels.
+	ObserveDuration(name string, labels map[string]string, d time.Duration)
+}
+
+func incCounter(m Metrics, name string, labels map[string]string) {
+	if m != nil {
+		m.IncCounter(name, labels)
+	}
+}
+
+func observeDuration(m Metrics, name string, labels map[string]string, start time.Time) {
+	if m != nil {
+		m.ObserveDuration(name, labels, time.Since(start))
+	}
+}
+
+// DefaultBuckets are the upper bounds in seconds of
+// the histogram buckets used by PrometheusMetrics.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// PrometheusMetrics keeps Metrics in memory and serves
+// them in the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	buckets    []float64
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+// NewPrometheusMetrics returns a new PrometheusMetrics, buckets
+// default to DefaultBuckets if none are given.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusMetrics{
+		buckets:    buckets,
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+// formatLabels encodes labels sorted by name.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escaper.Replace(labels[name])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p *PrometheusMetrics) IncCounter(name string, labels map[string]string) {
+	key := formatLabels(labels)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.counters[name]; !ok {
+		p.counters[name] = map[string]float64{}
+	}
+	p.counters[name][key]++
+}
+
+func (p *PrometheusMetrics) ObserveDuration(name string, labels map[string]string, d time.Duration) {
+	key := formatLabels(labels)
+	seconds := d.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.histograms[name]; !ok {
+		p.histograms[name] = map[string]*histogram{}
+	}
+	h, ok := p.histograms[name][key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.histograms[name][key] = h
+	}
+	for i, bound := range p.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// series formats a metric name with labels.
+func series(name, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+// withLabel appends a label to formatted labels.
+func withLabel(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Format returns every metric in the
+// Prometheus text exposition format.
+func (p *PrometheusMetrics) Format() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buf := bytes.NewBuffer(nil)
+	names := map[string]struct{}{}
+	for name := range p.counters {
+		names[name] = struct{}{}
+	}
+	for _, name := range sortedKeys(names) {
+		fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+		keys := map[string]struct{}{}
+		for key := range p.counters[name] {
+			keys[key] = struct{}{}
+		}
+		for _, key := range sortedKeys(keys) {
+			fmt.Fprintf(buf, "%s %s\n", series(name, key), strconv.FormatFloat(p.counters[name][key], 'g', -1, 64))
+		}
+	}
+	names = map[string]struct{}{}
+	for name := range p.histograms {
+		names[name] = struct{}{}
+	}
+	for _, name := range sortedKeys(names) {
+		fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+		keys := map[string]struct{}{}
+		for key := range p.histograms[name] {
+			keys[key] = struct{}{}
+		}
+		for _, key := range sortedKeys(keys) {
+			h := p.histograms[name][key]
+			for i, bound := range p.buckets {
+				le := withLabel(key, fmt.Sprintf(`le="%s"`, strconv.FormatFloat(bound, 'g', -1, 64)))
+				fmt.Fprintf(buf, "%s %d\n", series(name+"_bucket", le), h.counts[i])
+			}
+			fmt.Fprintf(buf, "%s %d\n", series(name+"_bucket", withLabel(key, `le="+Inf"`)), h.count)
+			fmt.Fprintf(buf, "%s %s\n", series(name+"_sum", key), strconv.FormatFloat(h.sum, 'g', -1, 64))
+			fmt.Fprintf(buf, "%s %d\n", series(name+"_count", key), h.count)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(p.Format())
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(raw []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(raw)
+}
+
+// instrument records the count and latency
+// of requests served by a route.
+func (s *Server) instrument(method, path string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r, p)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		labels := map[string]string{"route": path, "method": method}
+		observeDuration(s.metrics, MetricServerDuration, labels, start)
+		labels["code"] = strconv.Itoa(sw.code)
+		incCounter(s.metrics, MetricServerRequests, labels)
+	}
+}
+
+// clientRoute replaces identifiers in the path of a
+// Client call with the parameter of the route.
+func clientRoute(path string) string {
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i-1] == "opengdpr_requests" || parts[i-1] == "requests" && i > 1 && parts[i-2] == "admin" {
+			parts[i] = ":id"
+		}
+	}
+	return strings.Join(parts, "/")
+}
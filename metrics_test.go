@@ -0,0 +1,94 @@
+package gdpr
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics(0.1, 1)
+	metrics.IncCounter("requests_total", map[string]string{"route": "/a", "code": "200"})
+	metrics.IncCounter("requests_total", map[string]string{"route": "/a", "code": "200"})
+	metrics.IncCounter("requests_total", map[string]string{"route": `/"b"`, "code": "500"})
+	metrics.ObserveDuration("duration_seconds", nil, 50*time.Millisecond)
+	metrics.ObserveDuration("duration_seconds", nil, 2*time.Second)
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, `# TYPE requests_total counter
+requests_total{code="200",route="/a"} 2
+requests_total{code="500",route="/\"b\""} 1
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="+Inf"} 2
+duration_seconds_sum 2.05
+duration_seconds_count 2
+`, w.Body.String())
+}
+
+func TestMetricsInstrumentation(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	_, proc := newServer()
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		Metrics:      metrics,
+	})
+	svr := httptest.NewServer(server)
+	defer svr.Close()
+	client := NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}, Metrics: metrics})
+	ctx := context.Background()
+	_, err := client.Status(ctx, "1234")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+	assert.Equal(t, 201, w.Code)
+	// Callbacks and verification failures
+	controller := NewServer(&ServerOptions{
+		Controller: &mockController{},
+		Verifier:   MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+		Metrics:    metrics,
+	})
+	ctrlSvr := httptest.NewServer(controller)
+	defer ctrlSvr.Close()
+	cb := &CallbackRequest{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED, StatusCallbackUrl: ctrlSvr.URL + "/opengdpr_callbacks"}
+	assert.NoError(t, Callback(cb, &CallbackOptions{
+		MaxAttempts: 1,
+		Signer:      MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+		Metrics:     metrics,
+	}))
+	assert.Error(t, Callback(cb, &CallbackOptions{
+		MaxAttempts: 1,
+		Signer:      MustNewSigner(&KeyOptions{KeyBytes: keyPairTwo[0]}),
+		Metrics:     metrics,
+	}))
+	output := string(metrics.Format())
+	for _, line := range []string{
+		`gdpr_client_requests_total{code="200",method="GET",route="/opengdpr_requests/:id"} 1`,
+		`gdpr_server_requests_total{code="200",method="GET",route="/opengdpr_requests/:id"} 1`,
+		`gdpr_server_requests_total{code="201",method="POST",route="/opengdpr_requests"} 1`,
+		`gdpr_server_requests_total{code="403",method="POST",route="/opengdpr_callbacks"} 1`,
+		`gdpr_submissions_total{subject_type="erasure"} 1`,
+		`gdpr_signature_verification_failures_total{side="server"} 1`,
+		`gdpr_callbacks_total{result="success"} 1`,
+		`gdpr_callbacks_total{result="failure"} 1`,
+		`gdpr_server_request_duration_seconds_count{method="GET",route="/opengdpr_requests/:id"} 1`,
+		`gdpr_callback_duration_seconds_count 2`,
+	} {
+		assert.True(t, strings.Contains(output, line+"\n"), line)
+	}
+}
+
+func TestClientRoute(t *testing.T) {
+	assert.Equal(t, "/opengdpr_requests/:id", clientRoute("/opengdpr_requests/1234"))
+	assert.Equal(t, "/opengdpr_requests", clientRoute("/opengdpr_requests?limit=10"))
+	assert.Equal(t, "/admin/requests/:id/transition", clientRoute("/admin/requests/1234/transition"))
+}
@@ -0,0 +1,343 @@
+package gdpr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ProcessorOptions describe a remote processor
+// registered with an Orchestrator.
+type ProcessorOptions struct {
+	// Unique domain of the processor.
+	Domain string
+	// Client configured with the endpoint and
+	// Verifier of the processor.
+	Client *Client
+	// Optional static DiscoveryResponse, if nil it
+	// is fetched from the processor when first needed.
+	Discovery *DiscoveryResponse
+}
+
+// OrchestratorOptions configure an Orchestrator.
+type OrchestratorOptions struct {
+	// Ledger recording every request sent,
+	// defaults to NewMemoryLedger.
+	Ledger Ledger
+	// Optional URL of the controller's callback
+	// endpoint. When set each processor is sent a
+	// callback URL identifying it so that callbacks
+	// can be matched to the processor they came from.
+	CallbackUrl string
+}
+
+// Summary aggregates the status of a single logical
+// request across every processor it was sent to.
+type Summary struct {
+	SubjectRequestId string
+	// Overall status of the request, it is only completed
+	// once every processor has finished processing it.
+	RequestStatus RequestStatus
+	Entries       []*LedgerEntry
+	// Domains of processors which have not finished
+	// by their expected completion time.
+	Overdue []string
+	// Domains of processors the request could
+	// not be submitted to.
+	Failed []string
+	// Domains of processors which could not be polled,
+	// their entries hold the last known status.
+	Unreachable []string
+}
+
+type orchestratedProcessor struct {
+	domain    string
+	client    *Client
+	discovery *DiscoveryResponse
+}
+
+// Orchestrator fans requests out to many processors on behalf
+// of a controller and tracks their progress. Orchestrator
+// implements the Controller interface so it can be served
+// by a Server to receive callbacks.
+type Orchestrator struct {
+	mu          sync.RWMutex
+	processors  map[string]*orchestratedProcessor
+	ledger      Ledger
+	callbackUrl string
+}
+
+// NewOrchestrator returns a new Orchestrator.
+func NewOrchestrator(opts *OrchestratorOptions) *Orchestrator {
+	ledger := opts.Ledger
+	if ledger == nil {
+		ledger = NewMemoryLedger()
+	}
+	return &Orchestrator{
+		processors:  map[string]*orchestratedProcessor{},
+		ledger:      ledger,
+		callbackUrl: opts.CallbackUrl,
+	}
+}
+
+// Register adds a processor which subsequent
+// requests will be sent to.
+func (o *Orchestrator) Register(opts *ProcessorOptions) error {
+	if opts.Domain == "" {
+		return clientError(ErrMissingRequiredField("domain"))
+	}
+	if opts.Client == nil {
+		return clientError(ErrMissingRequiredField("client"))
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.processors[opts.Domain] = &orchestratedProcessor{
+		domain:    opts.Domain,
+		client:    opts.Client,
+		discovery: opts.Discovery,
+	}
+	return nil
+}
+
+func (o *Orchestrator) processor(domain string) (*orchestratedProcessor, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	proc, ok := o.processors[domain]
+	if !ok {
+		return nil, clientError(ErrUnknownProcessor(domain))
+	}
+	return proc, nil
+}
+
+// supports reports whether the processor accepts
+// requests of the given subject type.
+func (o *Orchestrator) supports(ctx context.Context, proc *orchestratedProcessor, st SubjectType) (bool, error) {
+	o.mu.RLock()
+	disc := proc.discovery
+	o.mu.RUnlock()
+	if disc == nil {
+		var err error
+		disc, err = proc.client.Discovery(ctx)
+		if err != nil {
+			return false, err
+		}
+		o.mu.Lock()
+		proc.discovery = disc
+		o.mu.Unlock()
+	}
+	for _, subjectType := range disc.SupportedSubjectRequestTypes {
+		if subjectType == st {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// callbackUrlFor returns the callback URL sent to the
+// processor with the given domain.
+func (o *Orchestrator) callbackUrlFor(domain string) (string, error) {
+	u, err := url.Parse(o.callbackUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("processor", domain)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// send submits the request to a single processor
+// and records the result in the ledger.
+func (o *Orchestrator) send(ctx context.Context, proc *orchestratedProcessor, req *Request) error {
+	entry := &LedgerEntry{
+		ProcessorDomain:  proc.domain,
+		SubjectRequestId: req.SubjectRequestId,
+		Request:          LedgerRequest(req),
+		RequestStatus:    STATUS_PENDING,
+	}
+	resp, err := proc.client.Request(ctx, req)
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Response = resp
+		entry.ExpectedCompletionTime = resp.ExpectedCompletionTime
+	}
+	entry.UpdatedTime = time.Now()
+	return o.ledger.Put(entry)
+}
+
+// Submit sends the request to every registered processor
+// which supports its subject type and returns a Summary
+// of the results. Failing to reach a processor does
+// not fail the submission, instead the processor
+// is reported in Summary.Failed.
+func (o *Orchestrator) Submit(ctx context.Context, req *Request) (*Summary, error) {
+	if req.SubjectRequestId == "" {
+		return nil, clientError(ErrMissingRequiredField("subject_request_id"))
+	}
+	o.mu.RLock()
+	var processors []*orchestratedProcessor
+	for _, proc := range o.processors {
+		processors = append(processors, proc)
+	}
+	o.mu.RUnlock()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		sendErr error
+	)
+	for _, proc := range processors {
+		wg.Add(1)
+		go func(proc *orchestratedProcessor) {
+			defer wg.Done()
+			supported, err := o.supports(ctx, proc, req.SubjectRequestType)
+			if err == nil && !supported {
+				return
+			}
+			// Each processor receives it's own copy
+			// of the request with a callback URL
+			// identifying it.
+			procReq := *req
+			if err == nil && o.callbackUrl != "" {
+				var cbUrl string
+				cbUrl, err = o.callbackUrlFor(proc.domain)
+				procReq.StatusCallbackUrls = append(append([]string{}, req.StatusCallbackUrls...), cbUrl)
+			}
+			if err != nil {
+				// Record the processor as failed
+				err = o.ledger.Put(&LedgerEntry{
+					ProcessorDomain:  proc.domain,
+					SubjectRequestId: req.SubjectRequestId,
+					Request:          LedgerRequest(&procReq),
+					RequestStatus:    STATUS_PENDING,
+					Error:            err.Error(),
+					UpdatedTime:      time.Now(),
+				})
+			} else {
+				err = o.send(ctx, proc, &procReq)
+			}
+			if err != nil {
+				mu.Lock()
+				sendErr = err
+				mu.Unlock()
+			}
+		}(proc)
+	}
+	wg.Wait()
+	if sendErr != nil {
+		return nil, sendErr
+	}
+	return o.Summary(req.SubjectRequestId)
+}
+
+// Update records a status reported by a processor
+// either via a callback or by polling.
+func (o *Orchestrator) Update(domain string, status *StatusResponse) error {
+	_, err := RecordStatus(o.ledger, domain, status)
+	return err
+}
+
+// Callback records a callback from a processor. The processor
+// is identified by the domain verified by the Server, which
+// should be served with ServerOptions.Verifiers so that each
+// processor's domain is bound to it's own key. Callbacks to a
+// URL generated for another processor are rejected, see
+// OrchestratorOptions.CallbackUrl.
+func (o *Orchestrator) Callback(cb *CallbackRequest) error {
+	domain := cb.ProcessorDomain
+	if _, err := o.processor(domain); err != nil {
+		return err
+	}
+	u, err := url.Parse(cb.StatusCallbackUrl)
+	if err != nil {
+		return ErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("bad status_callback_url: %s", err)}
+	}
+	if target := u.Query().Get("processor"); target != "" && target != domain {
+		return ErrorResponse{Code: http.StatusForbidden, Message: fmt.Sprintf("callback for %s sent by %s", target, domain)}
+	}
+	return o.Update(domain, callbackStatus(cb))
+}
+
+// Poll fetches the current status of the request from
+// every processor which has not yet finished it and
+// returns an updated Summary. Processors which cannot
+// be polled are reported in Summary.Unreachable.
+func (o *Orchestrator) Poll(ctx context.Context, id string) (*Summary, error) {
+	entries, err := o.ledger.List(id)
+	if err != nil {
+		return nil, err
+	}
+	var unreachable []string
+	for _, entry := range entries {
+		if entry.Failed() || entry.RequestStatus.Finished() {
+			continue
+		}
+		if err := o.poll(ctx, entry); err != nil {
+			unreachable = append(unreachable, entry.ProcessorDomain)
+		}
+	}
+	summary, err := o.Summary(id)
+	if err != nil {
+		return nil, err
+	}
+	summary.Unreachable = unreachable
+	return summary, nil
+}
+
+// poll updates a single entry with the
+// current status from it's processor.
+func (o *Orchestrator) poll(ctx context.Context, entry *LedgerEntry) error {
+	proc, err := o.processor(entry.ProcessorDomain)
+	if err != nil {
+		return err
+	}
+	status, err := proc.client.Status(ctx, entry.SubjectRequestId)
+	if err != nil {
+		return err
+	}
+	if status.SubjectRequestId != entry.SubjectRequestId {
+		return fmt.Errorf("%s responded with status of request %s", proc.domain, status.SubjectRequestId)
+	}
+	return o.Update(entry.ProcessorDomain, status)
+}
+
+// Summary aggregates the recorded status of
+// the request across every processor.
+func (o *Orchestrator) Summary(id string) (*Summary, error) {
+	entries, err := o.ledger.List(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, clientError(ErrNotFound(id))
+	}
+	summary := &Summary{SubjectRequestId: id, Entries: entries}
+	now := time.Now()
+	counts := map[RequestStatus]int{}
+	active := 0
+	for _, entry := range entries {
+		if entry.Failed() {
+			summary.Failed = append(summary.Failed, entry.ProcessorDomain)
+			continue
+		}
+		if entry.Overdue(now) {
+			summary.Overdue = append(summary.Overdue, entry.ProcessorDomain)
+		}
+		counts[entry.RequestStatus]++
+		active++
+	}
+	switch {
+	case active > 0 && counts[STATUS_CANCELLED] == active:
+		summary.RequestStatus = STATUS_CANCELLED
+	case active > 0 && counts[STATUS_COMPLETED]+counts[STATUS_CANCELLED] == active:
+		summary.RequestStatus = STATUS_COMPLETED
+	case counts[STATUS_IN_PROGRESS] > 0 || counts[STATUS_COMPLETED] > 0:
+		summary.RequestStatus = STATUS_IN_PROGRESS
+	default:
+		summary.RequestStatus = STATUS_PENDING
+	}
+	return summary, nil
+}
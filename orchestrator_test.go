@@ -0,0 +1,115 @@
+package gdpr
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newOrchestratedProcessor(t *testing.T, o *Orchestrator, domain string, subjectTypes ...SubjectType) (*mockProcessor, func()) {
+	proc := &mockProcessor{
+		response: &Response{
+			SubjectRequestId:       "1234",
+			ExpectedCompletionTime: time.Now().Add(time.Hour),
+		},
+		statusResponse: &StatusResponse{
+			SubjectRequestId: "1234",
+			RequestStatus:    STATUS_IN_PROGRESS,
+		},
+	}
+	svr := httptest.NewServer(NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		SubjectTypes: subjectTypes,
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+	}))
+	assert.NoError(t, o.Register(&ProcessorOptions{
+		Domain: domain,
+		Client: NewClient(&ClientOptions{Endpoint: svr.URL, Verifier: NoopVerifier{}}),
+	}))
+	return proc, svr.Close
+}
+
+func TestOrchestrator(t *testing.T) {
+	o := NewOrchestrator(&OrchestratorOptions{CallbackUrl: "https://controller.com/opengdpr_callbacks"})
+	one, closeOne := newOrchestratedProcessor(t, o, "one.com", SUBJECT_ERASURE)
+	defer closeOne()
+	_, closeTwo := newOrchestratedProcessor(t, o, "two.com", SUBJECT_ERASURE, SUBJECT_ACCESS)
+	defer closeTwo()
+	_, closeThree := newOrchestratedProcessor(t, o, "three.com", SUBJECT_ACCESS)
+	defer closeThree()
+	// A processor which cannot be reached
+	assert.NoError(t, o.Register(&ProcessorOptions{
+		Domain:    "down.com",
+		Client:    NewClient(&ClientOptions{Endpoint: "http://localhost:1", Verifier: NoopVerifier{}}),
+		Discovery: &DiscoveryResponse{SupportedSubjectRequestTypes: []SubjectType{SUBJECT_ERASURE}},
+	}))
+	summary, err := o.Submit(context.Background(), &Request{
+		SubjectRequestId:   "1234",
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_PENDING, summary.RequestStatus)
+	assert.Len(t, summary.Entries, 3)
+	assert.Equal(t, []string{"down.com"}, summary.Failed)
+	assert.Equal(t, "https://controller.com/opengdpr_callbacks?processor=one.com", summary.Entries[1].Request.StatusCallbackUrls[0])
+	// Callbacks are matched to their verified processor
+	assert.NoError(t, o.Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_COMPLETED,
+		StatusCallbackUrl: "https://controller.com/opengdpr_callbacks?processor=two.com",
+		ProcessorDomain:   "two.com",
+	}))
+	assert.Error(t, o.Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_COMPLETED,
+		StatusCallbackUrl: "https://controller.com/opengdpr_callbacks?processor=evil.com",
+		ProcessorDomain:   "evil.com",
+	}))
+	assert.Error(t, o.Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_COMPLETED,
+		StatusCallbackUrl: "https://controller.com/opengdpr_callbacks?processor=three.com",
+		ProcessorDomain:   "three.com",
+	}))
+	// One processor cannot update another's entry
+	err = o.Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_CANCELLED,
+		StatusCallbackUrl: "https://controller.com/opengdpr_callbacks?processor=one.com",
+		ProcessorDomain:   "two.com",
+	})
+	assert.Equal(t, 403, err.(ErrorResponse).Code)
+	// Polling updates unfinished processors
+	summary, err = o.Poll(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_IN_PROGRESS, summary.RequestStatus)
+	assert.Equal(t, STATUS_IN_PROGRESS, summary.Entries[1].RequestStatus)
+	assert.Equal(t, STATUS_COMPLETED, summary.Entries[2].RequestStatus)
+	assert.Len(t, summary.Overdue, 0)
+	// Overdue processors are reported
+	one.statusResponse = &StatusResponse{
+		SubjectRequestId:       "1234",
+		RequestStatus:          STATUS_IN_PROGRESS,
+		ExpectedCompletionTime: time.Now().Add(-time.Hour),
+	}
+	summary, err = o.Poll(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one.com"}, summary.Overdue)
+	// Processors which fail to respond do not fail the poll
+	one.err = ErrorResponse{Code: 500, Message: "Oh No!"}
+	summary, err = o.Poll(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one.com"}, summary.Unreachable)
+	one.err = nil
+	one.statusResponse = &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED}
+	summary, err = o.Poll(context.Background(), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, summary.RequestStatus)
+	_, err = o.Summary("4321")
+	assert.Error(t, err)
+}
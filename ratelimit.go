@@ -0,0 +1,271 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxBuckets is the number of token buckets kept before
+// idle buckets are discarded.
+const maxBuckets = 10000
+
+// RateLimit is a token bucket allowing Burst requests at
+// once which are replenished at Rate requests per second,
+// Rate must be positive.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitOptions limit the requests made to a Server. Rate
+// limits and daily quotas apply to each caller identified by
+// an Authenticator, or by their IP address otherwise. Quotas
+// are only charged for submissions the Server accepts.
+type RateLimitOptions struct {
+	// Optional limit of the requests from each IP address
+	// to every route, applied before callers are
+	// authenticated.
+	PerIP *RateLimit
+	// Limits keyed by method and route,
+	// e.g. "POST /opengdpr_requests".
+	Routes map[string]RateLimit
+	// Optional limit of routes without
+	// an entry in Routes.
+	Default *RateLimit
+	// Maximum number of requests of each subject type
+	// a caller may submit per day, days begin at
+	// midnight UTC.
+	DailyQuotas map[SubjectType]int
+	// Optional clock, defaults to time.Now.
+	Now func() time.Time
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// take removes a token from the bucket returning zero or the
+// time until a token will be available.
+func (b *bucket) take(now time.Time) time.Duration {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// full reports whether the bucket would have refilled.
+func (b *bucket) full(now time.Time) bool {
+	return now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// rateLimiter enforces RateLimitOptions.
+type rateLimiter struct {
+	opts    RateLimitOptions
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// quota usage of the current day
+	day   string
+	usage map[string]int
+}
+
+func newRateLimiter(opts *RateLimitOptions) *rateLimiter {
+	if opts == nil {
+		return nil
+	}
+	if opts.PerIP != nil && opts.PerIP.Rate <= 0 {
+		panic(fmt.Errorf("rate limit per IP has Rate %v, it must be positive", opts.PerIP.Rate))
+	}
+	for route, limit := range opts.Routes {
+		if limit.Rate <= 0 {
+			panic(fmt.Errorf("rate limit of %s has Rate %v, it must be positive", route, limit.Rate))
+		}
+	}
+	if opts.Default != nil && opts.Default.Rate <= 0 {
+		panic(fmt.Errorf("default rate limit has Rate %v, it must be positive", opts.Default.Rate))
+	}
+	limiter := &rateLimiter{
+		opts:    *opts,
+		buckets: map[string]*bucket{},
+		usage:   map[string]int{},
+	}
+	if limiter.opts.Now == nil {
+		limiter.opts.Now = time.Now
+	}
+	return limiter
+}
+
+// limit returns the RateLimit of a route if any.
+func (l *rateLimiter) limit(route string) (RateLimit, bool) {
+	if limit, ok := l.opts.Routes[route]; ok {
+		return limit, true
+	}
+	if l.opts.Default != nil {
+		return *l.opts.Default, true
+	}
+	return RateLimit{}, false
+}
+
+// allow takes a token from the caller's bucket for the route
+// returning zero or the time until a token is available.
+func (l *rateLimiter) allow(route, caller string) time.Duration {
+	limit, ok := l.limit(route)
+	if !ok {
+		return 0
+	}
+	return l.take(route+" "+caller, limit)
+}
+
+// allowIP takes a token from the bucket of an IP address
+// shared by every route.
+func (l *rateLimiter) allowIP(ip string) time.Duration {
+	if l.opts.PerIP == nil {
+		return 0
+	}
+	return l.take("ip "+ip, *l.opts.PerIP)
+}
+
+// take takes a token from the bucket with the given key.
+func (l *rateLimiter) take(key string, limit RateLimit) time.Duration {
+	now := l.opts.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buckets) >= maxBuckets {
+		// Discard buckets which would have refilled
+		for key, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, key)
+			}
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	return b.take(now)
+}
+
+// consume records a submission of the given subject type
+// against the caller's daily quota returning zero or the
+// time until the quota is reset. Unless the quota is
+// exceeded a function to refund the submission is
+// returned.
+func (l *rateLimiter) consume(st SubjectType, caller string) (func(), time.Duration) {
+	quota, ok := l.opts.DailyQuotas[st]
+	if !ok {
+		return func() {}, 0
+	}
+	now := l.opts.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	day := now.Format("2006-01-02")
+	if day != l.day {
+		l.day = day
+		l.usage = map[string]int{}
+	}
+	key := string(st) + " " + caller
+	if l.usage[key] >= quota {
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return nil, midnight.Sub(now)
+	}
+	l.usage[key]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.day == day && l.usage[key] > 0 {
+			l.usage[key]--
+		}
+	}, 0
+}
+
+// remoteIP returns the IP address of the caller.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// callerKey identifies the caller of a request by the
+// identity returned by an Authenticator if any or by
+// it's IP address.
+func callerKey(r *http.Request, p httprouter.Params) string {
+	if id := p.ByName(ControllerIdParam); id != "" {
+		return id
+	}
+	return remoteIP(r)
+}
+
+// rateLimit rejects requests from a caller which exceed
+// the rate limit of the route, it runs after callers are
+// authenticated.
+func (s *Server) rateLimit(method, path string, next httprouter.Handle) httprouter.Handle {
+	route := method + " " + path
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if wait := s.limiter.allow(route, callerKey(r, p)); wait > 0 {
+			s.rateLimited(w, route, wait)
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+// rateLimitIP rejects requests from an IP address which
+// exceed RateLimitOptions.PerIP, it runs before callers
+// are authenticated.
+func (s *Server) rateLimitIP(method, path string, next httprouter.Handle) httprouter.Handle {
+	route := method + " " + path
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if wait := s.limiter.allowIP(remoteIP(r)); wait > 0 {
+			s.rateLimited(w, route, wait)
+			return
+		}
+		next(w, r, p)
+	}
+}
+
+func (s *Server) rateLimited(w http.ResponseWriter, route string, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfter(wait))
+	s.setHeaders(w)
+	s.error(w, ErrRateLimited(route, wait))
+}
+
+// chargeQuota charges a submission against the caller's
+// daily quota of it's subject type. The returned function
+// refunds the charge and must be called unless the
+// submission is accepted.
+func (s *Server) chargeQuota(w http.ResponseWriter, r *http.Request, p httprouter.Params, raw []byte) (func(), error) {
+	req := struct {
+		SubjectRequestType SubjectType `json:"subject_request_type"`
+	}{}
+	if json.Unmarshal(raw, &req) != nil {
+		// Rejected as an invalid payload
+		return func() {}, nil
+	}
+	refund, wait := s.limiter.consume(req.SubjectRequestType, callerKey(r, p))
+	if wait > 0 {
+		w.Header().Set("Retry-After", retryAfter(wait))
+		return nil, ErrRateLimited("daily quota of "+string(req.SubjectRequestType)+" requests", wait)
+	}
+	return refund, nil
+}
+
+// retryAfter formats a duration as the whole
+// number of seconds for the Retry-After header.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,151 @@
+package gdpr
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerRateLimit(t *testing.T) {
+	now := time.Date(2018, 10, 2, 23, 59, 0, 0, time.UTC)
+	proc := &mapProcessor{
+		mockProcessor: mockProcessor{response: &Response{SubjectRequestId: "1234"}},
+		statuses: map[string]*StatusResponse{
+			"1234": &StatusResponse{SubjectRequestId: "1234", RequestStatus: STATUS_PENDING, ControllerId: "one.com"},
+			"4321": &StatusResponse{SubjectRequestId: "4321", RequestStatus: STATUS_PENDING, ControllerId: "two.com"},
+		},
+	}
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		ControllerAuthenticator: NewTokenAuthenticator(map[string]string{
+			"one": "one.com",
+			"two": "two.com",
+		}),
+		RateLimit: &RateLimitOptions{
+			PerIP: &RateLimit{Rate: 1, Burst: 10},
+			Routes: map[string]RateLimit{
+				"GET /opengdpr_requests/:id": RateLimit{Rate: 1, Burst: 2},
+			},
+			DailyQuotas: map[SubjectType]int{SUBJECT_ERASURE: 1},
+			Now:         func() time.Time { return now },
+		},
+	})
+	status := func(token, ip string) *httptest.ResponseRecorder {
+		id := map[string]string{"one": "1234", "two": "4321", "bad": "1234"}[token]
+		r := httptest.NewRequest("GET", "/opengdpr_requests/"+id, nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set(ApiKeyHeader, token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+	submit := func(token string, body ...[]byte) *httptest.ResponseRecorder {
+		if len(body) == 0 {
+			body = [][]byte{mockRequestBody}
+		}
+		r := httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(body[0]))
+		r.Header.Set(ApiKeyHeader, token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+	// Each controller has it's own bucket
+	// whichever address it calls from
+	assert.Equal(t, 200, status("one", "192.0.2.1").Code)
+	assert.Equal(t, 200, status("one", "192.0.2.2").Code)
+	w := status("one", "192.0.2.1")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	resp := &ErrorResponse{}
+	assert.NoError(t, resp.UnmarshalJSON(w.Body.Bytes()))
+	assert.Equal(t, 429, resp.Code)
+	// Controllers behind the same address are not limited together
+	assert.Equal(t, 200, status("two", "192.0.2.1").Code)
+	// Each address is limited before authentication
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 401, status("bad", "192.0.2.3").Code)
+	}
+	assert.Equal(t, 429, status("bad", "192.0.2.3").Code)
+	now = now.Add(time.Second)
+	assert.Equal(t, 200, status("one", "192.0.2.1").Code)
+	// Daily quota per subject type which is
+	// not charged for rejected submissions
+	invalid := bytes.Replace(mockRequestBody, []byte(`"email"`), []byte(`"phone"`), 1)
+	assert.Equal(t, 400, submit("one", invalid).Code)
+	proc.err = ErrorResponse{Code: 500, Message: "Oh No!"}
+	assert.Equal(t, 500, submit("one").Code)
+	proc.err = nil
+	assert.Equal(t, 201, submit("one").Code)
+	w = submit("one")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "59", w.Header().Get("Retry-After"))
+	assert.Equal(t, 201, submit("two").Code)
+	now = now.Add(time.Minute)
+	assert.Equal(t, 201, submit("one").Code)
+}
+
+func TestServerQuotaReplay(t *testing.T) {
+	now := time.Date(2018, 10, 2, 12, 0, 0, 0, time.UTC)
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    &mockProcessor{response: &Response{SubjectRequestId: "a7551968-d5d6-44b2-9831-815ac9017798"}},
+		Submissions:  NewMemorySubmissionStore(),
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		RateLimit: &RateLimitOptions{
+			DailyQuotas: map[SubjectType]int{SUBJECT_ERASURE: 2},
+			Now:         func() time.Time { return now },
+		},
+	})
+	submit := func(body []byte) int {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(body)))
+		return w.Code
+	}
+	// Retries of an accepted submission are not charged
+	assert.Equal(t, 201, submit(mockRequestBody))
+	assert.Equal(t, 201, submit(mockRequestBody))
+	other := bytes.Replace(mockRequestBody, []byte("a7551968"), []byte("b7551968"), 1)
+	assert.Equal(t, 201, submit(other))
+	third := bytes.Replace(mockRequestBody, []byte("a7551968"), []byte("c7551968"), 1)
+	assert.Equal(t, 429, submit(third))
+}
+
+func TestRateLimiterRate(t *testing.T) {
+	assert.Panics(t, func() {
+		newRateLimiter(&RateLimitOptions{Routes: map[string]RateLimit{"GET /": RateLimit{Burst: 1}}})
+	})
+	assert.Panics(t, func() {
+		newRateLimiter(&RateLimitOptions{Default: &RateLimit{Rate: -1, Burst: 1}})
+	})
+	assert.Panics(t, func() {
+		newRateLimiter(&RateLimitOptions{PerIP: &RateLimit{Burst: 1}})
+	})
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	now := time.Date(2018, 10, 2, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(&RateLimitOptions{
+		Routes: map[string]RateLimit{
+			"slow": RateLimit{Rate: 0.001, Burst: 1},
+			"fast": RateLimit{Rate: 100, Burst: 1},
+		},
+		Now: func() time.Time { return now },
+	})
+	assert.Equal(t, time.Duration(0), limiter.allow("slow", "caller"))
+	for i := 1; i < maxBuckets; i++ {
+		limiter.allow("fast", strconv.Itoa(i))
+	}
+	// Pruning for the fast route keeps the
+	// bucket of the slow route
+	now = now.Add(time.Second)
+	limiter.allow("fast", "new")
+	assert.True(t, limiter.allow("slow", "caller") > 0)
+}
@@ -0,0 +1,132 @@
+package gdpr
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReconcilerOptions configure a Reconciler.
+type ReconcilerOptions struct {
+	// Ledger of requests sent by the controller.
+	Ledger Ledger
+	// Clients used to poll each processor
+	// keyed by processor domain.
+	Clients map[string]*Client
+	// Optional Controller notified when polling
+	// discovers a status change, as if a callback
+	// had been received.
+	Controller Controller
+	// Only entries which have not been updated for
+	// this long are polled, defaults to one hour.
+	After time.Duration
+	// Interval between reconciliations
+	// when using Run, defaults to After.
+	Interval time.Duration
+}
+
+// Reconciler polls the status of requests whose
+// callbacks have not arrived and records any
+// changes in the Ledger.
+type Reconciler struct {
+	opts ReconcilerOptions
+}
+
+// NewReconciler returns a new Reconciler.
+func NewReconciler(opts *ReconcilerOptions) *Reconciler {
+	reconciler := &Reconciler{opts: *opts}
+	if reconciler.opts.After == 0 {
+		reconciler.opts.After = time.Hour
+	}
+	if reconciler.opts.Interval == 0 {
+		reconciler.opts.Interval = reconciler.opts.After
+	}
+	return reconciler
+}
+
+// Reconcile makes a single pass over every pending entry
+// in the Ledger and returns the number of entries whose
+// status changed. Entries for processors without a
+// Client are skipped and a failure to reconcile one
+// entry does not prevent the others being reconciled,
+// the first error encountered is returned.
+func (r *Reconciler) Reconcile(ctx context.Context) (int, error) {
+	entries, err := r.opts.Ledger.Pending()
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	var firstErr error
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return changed, err
+		}
+		if time.Since(entry.UpdatedTime) < r.opts.After {
+			continue
+		}
+		client, ok := r.opts.Clients[entry.ProcessorDomain]
+		if !ok {
+			continue
+		}
+		status, err := client.Status(ctx, entry.SubjectRequestId)
+		if err == nil && status.SubjectRequestId != entry.SubjectRequestId {
+			err = fmt.Errorf("%s responded with status of request %s", entry.ProcessorDomain, status.SubjectRequestId)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		previous, advanced, err := recordStatus(r.opts.Ledger, entry.ProcessorDomain, status)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !advanced {
+			continue
+		}
+		if r.opts.Controller != nil {
+			err = r.opts.Controller.Callback(&CallbackRequest{
+				ControllerId:           status.ControllerId,
+				ExpectedCompletionTime: status.ExpectedCompletionTime,
+				SubjectRequestId:       status.SubjectRequestId,
+				RequestStatus:          status.RequestStatus,
+				ResultsUrl:             status.ResultsUrl,
+				ProcessorDomain:        entry.ProcessorDomain,
+			})
+			if err != nil {
+				// Revert so the next pass delivers it again
+				if rerr := revertStatus(r.opts.Ledger, previous, status.RequestStatus); rerr != nil {
+					err = rerr
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		changed++
+	}
+	return changed, firstErr
+}
+
+// Run reconciles the Ledger at each Interval until the
+// context is done. Errors are passed to onError if set
+// and do not stop subsequent reconciliations.
+func (r *Reconciler) Run(ctx context.Context, onError func(error)) error {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reconcile(ctx); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package gdpr
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// SignerPolicy determines how a processor Server
+// responds when it's Signer fails.
+type SignerPolicy string
+
+const (
+	// SIGNER_FAIL fails the request with a 500 ErrorResponse.
+	SIGNER_FAIL = SignerPolicy("fail")
+	// SIGNER_FALLBACK retries with ServerOptions.FallbackSigner
+	// and fails the request if it also fails.
+	SIGNER_FALLBACK = SignerPolicy("fallback")
+	// SIGNER_UNHEALTHY fails the request and marks the
+	// Server unhealthy until it is restarted.
+	SIGNER_UNHEALTHY = SignerPolicy("unhealthy")
+)
+
+// onError logs an error and reports
+// it through Hooks.OnError.
+func (s *Server) onError(r *http.Request, err error) {
+	logEvent(s.logger, EventError, map[string]interface{}{"route": r.URL.Path, "error": err})
+	if s.hooks != nil && s.hooks.OnError != nil {
+		s.hooks.OnError(r, err)
+	}
+}
+
+// recover converts panics raised while serving a
+// route into a 500 ErrorResponse. Nothing is written
+// to the response until a route has finished so the
+// ErrorResponse can always be sent.
+func (s *Server) recover(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		defer s.recovered(w, r)
+		next(w, r, p)
+	}
+}
+
+// recoverHandler converts panics raised by Middleware
+// into a 500 ErrorResponse, the response may already
+// have been written by the Middleware.
+func (s *Server) recoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer s.recovered(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recovered must be deferred, it recovers any panic and
+// sends a 500 ErrorResponse. http.ErrAbortHandler is
+// panicked again so the response is aborted.
+func (s *Server) recovered(w http.ResponseWriter, r *http.Request) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	if value == http.ErrAbortHandler {
+		panic(value)
+	}
+	s.onError(r, &PanicError{Value: value, Stack: debug.Stack()})
+	s.error(w, ErrorResponse{Code: http.StatusInternalServerError, Message: "internal server error"})
+}
+
+// signResponse signs the response body applying the
+// SignerPolicy if the Signer fails.
+func (s *Server) signResponse(w http.ResponseWriter, r *http.Request, body []byte) (string, error) {
+	signature, err := s.sign(s.signer, w, body)
+	if err == nil {
+		return signature, nil
+	}
+	err = fmt.Errorf("cannot sign response: %w", err)
+	s.onError(r, err)
+	switch s.signerPolicy {
+	case SIGNER_FALLBACK:
+		if s.fallbackSigner != nil {
+			signature, err = s.sign(s.fallbackSigner, w, body)
+			if err == nil {
+				return signature, nil
+			}
+			s.onError(r, fmt.Errorf("cannot sign response with fallback signer: %w", err))
+		}
+	case SIGNER_UNHEALTHY:
+		s.unhealthy.Store(err)
+	}
+	return "", ErrSigningFailed()
+}
+
+// Healthy returns the error which marked the Server
+// unhealthy, see SIGNER_UNHEALTHY, or nil.
+func (s *Server) Healthy() error {
+	if err, ok := s.unhealthy.Load().(error); ok {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package gdpr
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type panicProcessor struct {
+	mockProcessor
+}
+
+func (panicProcessor) Status(id string) (*StatusResponse, error) {
+	panic("boom")
+}
+
+type failingSigner struct{}
+
+func (failingSigner) Sign([]byte) (string, error) { return "", errors.New("hsm unavailable") }
+
+func TestServerRecover(t *testing.T) {
+	var reported []error
+	server := NewServer(&ServerOptions{
+		Signer:    NoopSigner{},
+		Processor: panicProcessor{},
+		Hooks: &Hooks{
+			OnError: func(r *http.Request, err error) { reported = append(reported, err) },
+		},
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests/1234", nil))
+	assert.Equal(t, 500, w.Code)
+	resp := &ErrorResponse{}
+	assert.NoError(t, resp.UnmarshalJSON(w.Body.Bytes()))
+	assert.Equal(t, 500, resp.Code)
+	assert.Len(t, reported, 1)
+	assert.Equal(t, "boom", reported[0].(*PanicError).Value)
+	assert.NotEmpty(t, reported[0].(*PanicError).Stack)
+}
+
+func TestServerRecoverMiddleware(t *testing.T) {
+	var reported []error
+	server := NewServer(&ServerOptions{
+		Signer:    NoopSigner{},
+		Processor: mockProcessor{},
+		Hooks: &Hooks{
+			OnError: func(r *http.Request, err error) { reported = append(reported, err) },
+		},
+		Middleware: []Middleware{func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Abort") != "" {
+					panic(http.ErrAbortHandler)
+				}
+				panic("boom")
+			})
+		}},
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/discovery", nil))
+	assert.Equal(t, 500, w.Code)
+	assert.Len(t, reported, 1)
+	// Aborted responses are not recovered
+	r := httptest.NewRequest("GET", "/discovery", nil)
+	r.Header.Set("X-Abort", "true")
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { server.ServeHTTP(httptest.NewRecorder(), r) })
+	server = NewServer(&ServerOptions{Signer: NoopSigner{}, Processor: abortProcessor{}})
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/opengdpr_requests/1234", nil))
+	})
+	assert.Len(t, reported, 1)
+}
+
+type abortProcessor struct {
+	mockProcessor
+}
+
+func (abortProcessor) Status(id string) (*StatusResponse, error) {
+	panic(http.ErrAbortHandler)
+}
+
+// flakySigner fails it's first signature.
+type flakySigner struct {
+	calls int
+}
+
+func (f *flakySigner) Sign(body []byte) (string, error) {
+	f.calls++
+	if f.calls == 1 {
+		return "", errors.New("hsm unavailable")
+	}
+	return "signature", nil
+}
+
+func TestServerSignerFailureReplay(t *testing.T) {
+	proc := &blockingProcessor{
+		mockProcessor: mockProcessor{response: &Response{SubjectRequestId: "a7551968-d5d6-44b2-9831-815ac9017798"}},
+		started:       make(chan struct{}, 2),
+		release:       make(chan struct{}),
+	}
+	close(proc.release)
+	server := NewServer(&ServerOptions{
+		Signer:       &flakySigner{},
+		Processor:    proc,
+		Submissions:  NewMemorySubmissionStore(),
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+	})
+	submit := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+		return w
+	}
+	assert.Equal(t, 500, submit().Code)
+	// The retry is replayed and signed
+	w := submit()
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "signature", w.Header().Get("X-OpenGDPR-Signature"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&proc.calls))
+}
+
+func TestServerSignerPolicy(t *testing.T) {
+	_, proc := newServer()
+	status := func(server *Server) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/opengdpr_requests/1234", nil))
+		return w
+	}
+	var reported []error
+	hooks := &Hooks{OnError: func(r *http.Request, err error) { reported = append(reported, err) }}
+	server := NewServer(&ServerOptions{Signer: failingSigner{}, Processor: proc, Hooks: hooks})
+	assert.Equal(t, 500, status(server).Code)
+	assert.Len(t, reported, 1)
+	assert.NoError(t, server.Healthy())
+	server = NewServer(&ServerOptions{
+		Signer:         failingSigner{},
+		Processor:      proc,
+		SignerPolicy:   SIGNER_FALLBACK,
+		FallbackSigner: MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+	})
+	w := status(server)
+	assert.Equal(t, 200, w.Code)
+	assert.NotEmpty(t, w.Header().Get("X-OpenGDPR-Signature"))
+	server = NewServer(&ServerOptions{
+		Signer:       failingSigner{},
+		Processor:    proc,
+		SignerPolicy: SIGNER_UNHEALTHY,
+	})
+	assert.NoError(t, server.Healthy())
+	assert.Equal(t, 500, status(server).Code)
+	assert.Error(t, server.Healthy())
+}
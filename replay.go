@@ -0,0 +1,172 @@
+package gdpr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// TimestampHeader carries the unix time a
+	// message was signed at.
+	TimestampHeader = "X-OpenGDPR-Timestamp"
+	// NonceHeader carries a random value unique
+	// to each signed message.
+	NonceHeader = "X-OpenGDPR-Nonce"
+)
+
+// DefaultMaxSkew is the default window in which
+// a signed timestamp is considered fresh.
+const DefaultMaxSkew = 5 * time.Minute
+
+// NonceCache records the nonces of messages
+// which have already been received.
+type NonceCache interface {
+	// Seen records the nonce and reports whether
+	// it has been recorded before. A nonce only
+	// needs to be retained until it expires.
+	Seen(nonce string, expires time.Time) (bool, error)
+}
+
+// NewMemoryNonceCache returns a NonceCache which
+// keeps unexpired nonces in memory.
+func NewMemoryNonceCache() NonceCache {
+	return &memoryNonceCache{nonces: map[string]time.Time{}}
+}
+
+type memoryNonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+func (m *memoryNonceCache) Seen(nonce string, expires time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	// Periodically drop any expired nonces
+	if now.Sub(m.pruned) > time.Minute {
+		for key, exp := range m.nonces {
+			if now.After(exp) {
+				delete(m.nonces, key)
+			}
+		}
+		m.pruned = now
+	}
+	if _, ok := m.nonces[nonce]; ok {
+		return true, nil
+	}
+	m.nonces[nonce] = expires
+	return false, nil
+}
+
+// ReplayOptions enable protection against signed
+// messages being captured and sent again. When enabled
+// signatures cover a timestamp and nonce sent in the
+// TimestampHeader and NonceHeader as well as the body.
+type ReplayOptions struct {
+	// Maximum difference allowed between a signed
+	// timestamp and the local clock, defaults
+	// to DefaultMaxSkew.
+	MaxSkew time.Duration
+	// Cache of previously received nonces,
+	// defaults to NewMemoryNonceCache.
+	Nonces NonceCache
+	// Optional clock, defaults to time.Now.
+	Now func() time.Time
+}
+
+// SignedPayload returns the bytes covered by a
+// signature when replay protection is enabled.
+func SignedPayload(timestamp, nonce string, body []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(timestamp)
+	buf.WriteString(".")
+	buf.WriteString(nonce)
+	buf.WriteString(".")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func newReplayOptions(opts *ReplayOptions) *ReplayOptions {
+	if opts == nil {
+		return nil
+	}
+	replay := *opts
+	if replay.MaxSkew == 0 {
+		replay.MaxSkew = DefaultMaxSkew
+	}
+	if replay.Nonces == nil {
+		replay.Nonces = NewMemoryNonceCache()
+	}
+	if replay.Now == nil {
+		replay.Now = time.Now
+	}
+	return &replay
+}
+
+// sign generates a new timestamp and nonce and
+// sets them in header along with a signature
+// covering both and the body.
+func (o *ReplayOptions) sign(signer Signer, header http.Header, body []byte) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(raw)
+	timestamp := strconv.FormatInt(o.Now().Unix(), 10)
+	signer, body, err := canonicalSignerPayload(signer, body)
+	if err != nil {
+		return "", err
+	}
+	signature, err := signer.Sign(SignedPayload(timestamp, nonce, body))
+	if err != nil {
+		return "", err
+	}
+	header.Set(TimestampHeader, timestamp)
+	header.Set(NonceHeader, nonce)
+	header.Set("X-OpenGDPR-Signature", signature)
+	return signature, nil
+}
+
+// verify checks the timestamp and nonce present
+// in header are fresh and covered by the signature.
+func (o *ReplayOptions) verify(verifier Verifier, header http.Header, body []byte) error {
+	timestamp, nonce := header.Get(TimestampHeader), header.Get(NonceHeader)
+	if timestamp == "" {
+		return ErrMissingRequiredField(TimestampHeader)
+	}
+	if nonce == "" {
+		return ErrMissingRequiredField(NonceHeader)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleMessage(timestamp)
+	}
+	signed := time.Unix(unix, 0)
+	skew := o.Now().Sub(signed)
+	if skew > o.MaxSkew || skew < -o.MaxSkew {
+		return ErrStaleMessage(timestamp)
+	}
+	verifier, body, err = canonicalVerifierPayload(verifier, body)
+	if err != nil {
+		return ErrInvalidRequestSignature(header.Get("X-OpenGDPR-Signature"), err)
+	}
+	err = verifier.Verify(SignedPayload(timestamp, nonce, body), header.Get("X-OpenGDPR-Signature"))
+	if err != nil {
+		return err
+	}
+	// Only record nonces of authentic messages
+	seen, err := o.Nonces.Seen(nonce, signed.Add(o.MaxSkew))
+	if err != nil {
+		return err
+	}
+	if seen {
+		return ErrReplayedMessage(nonce)
+	}
+	return nil
+}
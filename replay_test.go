@@ -0,0 +1,128 @@
+package gdpr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockController struct {
+	callbacks []*CallbackRequest
+	// number of callbacks to fail
+	failures int
+}
+
+func (m *mockController) Callback(req *CallbackRequest) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("unavailable")
+	}
+	m.callbacks = append(m.callbacks, req)
+	return nil
+}
+
+func TestReplayProtection(t *testing.T) {
+	signer := MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]})
+	controller := &mockController{}
+	server := NewServer(&ServerOptions{
+		Controller: controller,
+		Verifier:   MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+		Replay:     &ReplayOptions{MaxSkew: time.Minute},
+	})
+	body, _ := json.Marshal(&CallbackRequest{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED})
+	newRequest := func(at time.Time) *http.Request {
+		replay := newReplayOptions(&ReplayOptions{Now: func() time.Time { return at }})
+		r := httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+		_, err := replay.sign(signer, r.Header, body)
+		assert.NoError(t, err)
+		return r
+	}
+	r := newRequest(time.Now())
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, controller.callbacks, 1)
+	// Replay the same message
+	replayed := httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+	replayed.Header = r.Header
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, replayed)
+	assert.Equal(t, 403, w.Code)
+	assert.Len(t, controller.callbacks, 1)
+	// Message signed outside the allowed window
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, newRequest(time.Now().Add(-2*time.Minute)))
+	assert.Equal(t, 403, w.Code)
+	// Timestamp not covered by the signature
+	r = newRequest(time.Now())
+	r.Header.Set(TimestampHeader, r.Header.Get(TimestampHeader)+"0")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 403, w.Code)
+	// Missing headers
+	r = httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, controller.callbacks, 1)
+}
+
+func TestReplayCallback(t *testing.T) {
+	controller := &mockController{}
+	svr := httptest.NewServer(NewServer(&ServerOptions{
+		Controller: controller,
+		Verifier:   MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+		Replay:     &ReplayOptions{},
+	}))
+	defer svr.Close()
+	err := Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_COMPLETED,
+		StatusCallbackUrl: svr.URL + "/opengdpr_callbacks",
+	}, &CallbackOptions{
+		MaxAttempts: 1,
+		Signer:      MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+		Replay:      &ReplayOptions{},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, controller.callbacks, 1)
+}
+
+func TestReplayCallbackRetry(t *testing.T) {
+	controller := &mockController{}
+	server := NewServer(&ServerOptions{
+		Controller: controller,
+		Verifier:   MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+		Replay:     &ReplayOptions{},
+	})
+	var codes []int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, r)
+		codes = append(codes, rec.Code)
+		// Lose the response of the first delivery
+		if len(codes) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(rec.Code)
+	}))
+	defer svr.Close()
+	err := Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_COMPLETED,
+		StatusCallbackUrl: svr.URL + "/opengdpr_callbacks",
+	}, &CallbackOptions{
+		MaxAttempts: 2,
+		Signer:      MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+		Replay:      &ReplayOptions{},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{200, 200}, codes)
+}
@@ -0,0 +1,69 @@
+package gdpr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures how a Client retries calls
+// which fail with a transient error.
+type RetryPolicy struct {
+	// Total number of attempts made for each call.
+	MaxAttempts int
+	// Delay before the first retry, doubled
+	// after each subsequent attempt.
+	Backoff time.Duration
+	// Optional upper bound on the delay
+	// between attempts.
+	MaxBackoff time.Duration
+	// Retry new request submissions, this is only
+	// safe when the processor detects duplicate
+	// submissions, see ServerOptions.Submissions.
+	RetryRequests bool
+}
+
+// backoff returns the delay before the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// sleepContext pauses for the given duration returning
+// early with an error if ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Retryable reports whether a call which failed with
+// err may succeed if attempted again. Connection errors
+// and server errors other than 501 Not Implemented are
+// considered transient.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var errResp *ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.Code >= 500 && errResp.Code != http.StatusNotImplemented
+	}
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return transportErr.Temporary()
+	}
+	return false
+}
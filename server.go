@@ -2,15 +2,46 @@ package gdpr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+const (
+	// ProcessorDomainHeader identifies the processor
+	// sending a callback to a controller.
+	ProcessorDomainHeader = "X-OpenGDPR-Processor-Domain"
+	// LegacyProcessorDomainHeader is the alternate
+	// spelling of ProcessorDomainHeader set on
+	// responses from a processor Server.
+	LegacyProcessorDomainHeader = "X-OpenGDPR-ProcessorDomain"
+)
+
+// processorDomain returns the processor domain
+// using either spelling of the header.
+func processorDomain(h http.Header) string {
+	if domain := h.Get(ProcessorDomainHeader); domain != "" {
+		return domain
+	}
+	return h.Get(LegacyProcessorDomainHeader)
+}
+
+// ProcessorDomainParam is the key of the httprouter.Params
+// entry holding the domain of the processor which sent a
+// callback to a controller.
+const ProcessorDomainParam = "processor_domain"
+
+// QueryParam is the key of the httprouter.Params
+// entry holding the raw query string of a request.
+const QueryParam = "query"
+
 // Controller makes new requests to a Processor
 // and processes Callback requests.
 type Controller interface {
@@ -40,6 +71,23 @@ type Processor interface {
 // json payload to resp.
 type Handler func(resp io.Writer, req io.Reader, p httprouter.Params) error
 
+// requestBody is the body passed to a Handler, it carries
+// the context of the HTTP request for handlers which make
+// outbound calls.
+type requestBody struct {
+	*bytes.Reader
+	ctx context.Context
+}
+
+// requestContext returns the context of the HTTP request
+// whose body is req, or context.Background if unknown.
+func requestContext(req io.Reader) context.Context {
+	if body, ok := req.(*requestBody); ok {
+		return body.ctx
+	}
+	return context.Background()
+}
+
 // Builder is a functional option to construct a Handler.
 type Builder func(opts *ServerOptions) Handler
 
@@ -69,6 +117,22 @@ type ServerOptions struct {
 	Signer Signer
 	// Verifies any incoming callbacks.
 	Verifier Verifier
+	// Optional map of Verifiers keyed by processor
+	// domain. When set callbacks are verified with
+	// the Verifier of the domain in the processor
+	// domain header and callbacks from unknown
+	// domains are rejected.
+	Verifiers map[string]Verifier
+	// Optional Ledger of requests sent by the
+	// controller. When set callbacks are matched to
+	// their request by subject_request_id and the
+	// processor domain header and callbacks for
+	// unknown requests are rejected.
+	Ledger Ledger
+	// Optional record of the callbacks delivered to the
+	// Controller used to ignore duplicate and stale
+	// callbacks when no Ledger is configured.
+	CallbackStates CallbackStates
 	// Array of identity types supported by
 	// the server.
 	Identities []Identity
@@ -84,6 +148,73 @@ type ServerOptions struct {
 	// of this server can be downloaded and used
 	// to verify subsequent response payload
 	ProcessorCertificateUrl string
+	// Optional store used to detect duplicate
+	// submissions of the same request. Retried
+	// requests with an identical payload receive
+	// the original response and signature.
+	Submissions SubmissionStore
+	// Optional replay protection. Processors sign
+	// a timestamp and nonce with each response and
+	// controllers reject callbacks which are stale
+	// or have already been received.
+	Replay *ReplayOptions
+	// Optional map of older specification versions
+	// the server accepts in addition to ApiVersion
+	// keyed by version. Defaults to DefaultMigrations.
+	Migrations map[string]Migration
+	// Optional Authenticator for endpoints outside of
+	// the specification such as request listing which
+	// are only served when it is set. Only callers it
+	// authenticates see the results of health checks.
+	Authenticator Authenticator
+	// Optional Authenticator for the admin API which
+	// is only served when it is set and the Processor
+	// implements Administrator. When set it is also
+	// required by any other route beneath AdminPrefix.
+	AdminAuthenticator Authenticator
+	// Options used to re-send callbacks
+	// from the admin API.
+	AdminCallback *CallbackOptions
+	// Optional Authenticator identifying the controller
+	// calling the processor. When set every request to
+	// the OpenGDPR endpoints must be authenticated and
+	// the identity of the controller is passed to the
+	// Processor as Request.ControllerId. The Processor
+	// must return it in StatusResponse.ControllerId,
+	// requests of other controllers are not found.
+	ControllerAuthenticator Authenticator
+	// Optional rate limits and daily quotas
+	// applied to each caller.
+	RateLimit *RateLimitOptions
+	// Optional Middleware wrapping the
+	// Server, see Server.Use.
+	Middleware []Middleware
+	// Optional Hooks called around
+	// each OpenGDPR operation.
+	Hooks *Hooks
+	// Policy applied when the Signer fails,
+	// defaults to SIGNER_FAIL.
+	SignerPolicy SignerPolicy
+	// Signer used by SIGNER_FALLBACK.
+	FallbackSigner Signer
+	// Optional HealthChecks run by /readyz in addition
+	// to the built in checks of the Signer and Verifiers.
+	HealthChecks []HealthCheck
+	// Optional Metrics recorded for each request.
+	Metrics Metrics
+	// Optional Tracer notified of the span
+	// serving each request.
+	Tracer Tracer
+	// Optional Logger of structured events.
+	Logger Logger
+	// Maximum size in bytes of a request body, defaults
+	// to DefaultMaxBodySize. Negative values disable
+	// the limit.
+	MaxBodySize int64
+	// When set payloads containing unknown fields
+	// or trailing data after the JSON value
+	// are rejected.
+	StrictJSON bool
 }
 
 // Server exposes an HTTP interface to an underlying
@@ -91,14 +222,38 @@ type ServerOptions struct {
 // for route matching, in the future we might expand
 // this to support other mux and frameworks.
 type Server struct {
-	handlerFn       http.HandlerFunc
-	signer          Signer
-	verifier        Verifier
-	isProcessor     bool
-	isController    bool
-	headers         http.Header
-	router          *httprouter.Router
-	processorDomain string
+	handlerFn          http.HandlerFunc
+	signer             Signer
+	verifier           Verifier
+	verifiers          map[string]Verifier
+	isProcessor        bool
+	isController       bool
+	headers            http.Header
+	router             *httprouter.Router
+	processorDomain    string
+	migrations         map[string]Migration
+	submissions        SubmissionStore
+	replay             *ReplayOptions
+	authenticator      Authenticator
+	adminAuthenticator Authenticator
+	// authenticates controllers calling a processor
+	controllerAuthenticator Authenticator
+	limiter                 *rateLimiter
+	middleware              []Middleware
+	hooks                   *Hooks
+	signerPolicy            SignerPolicy
+	fallbackSigner          Signer
+	// holds the error marking the server unhealthy
+	unhealthy *atomic.Value
+	// guards checks
+	checksMu *sync.Mutex
+	checks   []HealthCheck
+	ready    *readyCache
+	metrics  Metrics
+	tracer   Tracer
+	logger   Logger
+	// maximum size of request bodies
+	maxBodySize int64
 }
 
 func (s *Server) setHeaders(w http.ResponseWriter) {
@@ -112,13 +267,20 @@ func (s *Server) setHeaders(w http.ResponseWriter) {
 // respCode maps any successful request with a
 // specific status code or returns 200.
 func (s *Server) respCode(r *http.Request) int {
-	if r.URL.Path == "/opengdpr_requests" && r.Method == "POST" {
+	if isSubmission(r) {
 		return http.StatusCreated
 	}
 	return http.StatusOK
 }
 
 func (s *Server) error(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = ErrBodyTooLarge(tooLarge.Limit)
+	}
+	if errResp, ok := err.(*ErrorResponse); ok {
+		err = *errResp
+	}
 	if err != nil {
 		w.Header().Set("Cache Control", "no-store")
 		switch e := err.(type) {
@@ -136,105 +298,329 @@ func (s *Server) error(w http.ResponseWriter, err error) bool {
 	return false
 }
 
+// sign generates a signature of the response body
+// and sets it in the response headers.
+func (s *Server) sign(signer Signer, w http.ResponseWriter, body []byte) (string, error) {
+	if s.replay != nil {
+		return s.replay.sign(signer, w.Header(), body)
+	}
+	signature, err := signer.Sign(body)
+	if err != nil {
+		return "", err
+	}
+	w.Header().Set("X-OpenGDPR-Signature", signature)
+	return signature, nil
+}
+
+// verify checks the signature of an incoming payload
+// with the Verifier of the processor which sent it.
+func (s *Server) verify(r *http.Request, raw []byte) error {
+	verifier := s.verifier
+	if s.verifiers != nil {
+		domain := processorDomain(r.Header)
+		v, ok := s.verifiers[domain]
+		if !ok {
+			return ErrUnknownProcessor(domain)
+		}
+		verifier = v
+	}
+	if s.replay != nil {
+		return s.replay.verify(verifier, r.Header, raw)
+	}
+	return verifier.Verify(raw, r.Header.Get("X-OpenGDPR-Signature"))
+}
+
+// negotiate resolves the version of the specification
+// spoken by the remote peer from the GDPR-Version header
+// falling back to the api_version of the payload. A nil
+// Migration is returned for ApiVersion.
+func (s *Server) negotiate(header string, raw []byte) (string, Migration, error) {
+	version := header
+	if version == "" {
+		version = payloadVersion(raw)
+	}
+	if version == "" || version == ApiVersion {
+		return ApiVersion, nil, nil
+	}
+	migration, ok := s.migrations[version]
+	if !ok {
+		return "", nil, ErrUnsupportedApiVersion(version)
+	}
+	return version, migration, nil
+}
+
 func (s *Server) handle(fn Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		s.setHeaders(w)
-		body := r.Body
-		// allocate a new buffer for the response body
-		buf := bytes.NewBuffer(nil)
+		raw, err := ioutil.ReadAll(r.Body)
+		if s.error(w, err) {
+			// Failed to read request body
+			return
+		}
+		version, migration, err := s.negotiate(r.Header.Get("GDPR-Version"), raw)
+		if s.error(w, err) {
+			// Unsupported version of the specification
+			return
+		}
+		w.Header().Set("GDPR-Version", version)
+		p = append(p, httprouter.Param{Key: QueryParam, Value: r.URL.RawQuery})
 		// If we are serving a controller validate
 		// the request before processing and further
 		if s.isController {
-			// Allocate a new buffer to copy the
-			// payload into after verification
-			reqBody := bytes.NewBuffer(nil)
-			raw, err := ioutil.ReadAll(io.TeeReader(r.Body, reqBody))
+			if err := s.verify(r, raw); err != nil {
+				// Signature verification failed
+				incCounter(s.metrics, MetricVerificationFailures, map[string]string{"side": "server"})
+				logEvent(s.logger, EventSignatureMismatch, map[string]interface{}{
+					"processor_domain": processorDomain(r.Header),
+					"route":            r.URL.Path,
+					"error":            err,
+				})
+				s.error(w, err)
+				return
+			}
+			p = append(p, httprouter.Param{
+				Key:   ProcessorDomainParam,
+				Value: processorDomain(r.Header),
+			})
+		}
+		// Convert the payload into the shape
+		// expected by the current ApiVersion
+		if migration != nil && len(raw) > 0 {
+			raw, err = migration.Upgrade(raw)
 			if s.error(w, err) {
-				// Failed to decode request body
 				return
 			}
-			if s.error(w, s.verifier.Verify(raw, r.Header.Get("X-OpenGDPR-Signature"))) {
-				// Signature verification failed
+		}
+		// Replay the original response of any
+		// previously accepted submission
+		var id, digest string
+		// Each controller has it's own submissions so the
+		// requests of others are not revealed.
+		controller := p.ByName(ControllerIdParam)
+		if s.submissions != nil && isSubmission(r) {
+			id, digest, _ = submissionDigest(raw)
+			if id != "" {
+				sub, err := s.submissions.Claim(&Submission{SubjectRequestId: id, ControllerId: controller, Digest: digest})
+				if s.error(w, err) {
+					return
+				}
+				if sub != nil {
+					if sub.Digest != digest {
+						s.error(w, ErrDuplicateRequest(id))
+						return
+					}
+					if sub.Pending {
+						w.Header().Set("Retry-After", "1")
+						s.error(w, ErrSubmissionInProgress(id))
+						return
+					}
+					// Submissions are kept in the current
+					// version and converted back for the peer
+					body := sub.Body
+					if migration != nil && len(body) > 0 {
+						body, err = migration.Downgrade(body)
+						if s.error(w, err) {
+							return
+						}
+					}
+					if s.replay == nil && migration == nil && sub.Signature != "" {
+						w.Header().Set("X-OpenGDPR-Signature", sub.Signature)
+					} else if _, err := s.signResponse(w, r, body); s.error(w, err) {
+						return
+					}
+					w.WriteHeader(s.respCode(r))
+					w.Write(body)
+					return
+				}
+				// Release the claim unless the
+				// submission is recorded below
+				defer func() {
+					if id != "" {
+						s.submissions.Release(controller, id)
+					}
+				}()
+			}
+		}
+		// Charge the daily quota, refunded unless
+		// the submission is accepted below
+		var refund func()
+		if s.limiter != nil && isSubmission(r) {
+			refund, err = s.chargeQuota(w, r, p, raw)
+			if s.error(w, err) {
 				return
 			}
-			// Set the body to the copied original payload
-			body = ioutil.NopCloser(reqBody)
+			defer func() {
+				if refund != nil {
+					refund()
+				}
+			}()
 		}
+		// allocate a new buffer for the response body
+		buf := bytes.NewBuffer(nil)
 		// satisfy the request and process any error
-		if s.error(w, fn(buf, body, p)) {
+		if s.error(w, fn(buf, &requestBody{Reader: bytes.NewReader(raw), ctx: r.Context()}, p)) {
 			return
 		}
+		body := buf.Bytes()
+		// Record the accepted submission before signing
+		// so a retry after a signing failure is replayed
+		// rather than processed again. It is kept in the
+		// current version like it's digest.
+		var sub *Submission
+		if id != "" {
+			sub = &Submission{
+				SubjectRequestId: id,
+				ControllerId:     controller,
+				Digest:           digest,
+				Body:             body,
+			}
+			if s.error(w, s.submissions.Put(sub)) {
+				return
+			}
+			id = ""
+		}
+		// Convert the response back into the
+		// version spoken by the remote peer
+		if migration != nil && len(body) > 0 {
+			body, err = migration.Downgrade(body)
+			if s.error(w, err) {
+				return
+			}
+		}
+		refund = nil
 		// If we are serving a processor add a
 		// signature of the response payload
 		// in our headers.
 		if s.isProcessor {
-			signature, err := s.signer.Sign(buf.Bytes())
-			if err != nil {
-				// fatal since we can't sign our own response
-				panic(fmt.Sprintf("cannot sign response: %s", err.Error()))
+			signature, err := s.signResponse(w, r, body)
+			if s.error(w, err) {
+				return
+			}
+			// Keep the signature so retries
+			// receive the exact response
+			if sub != nil && s.replay == nil && migration == nil {
+				signed := *sub
+				signed.Signature = signature
+				if s.error(w, s.submissions.Put(&signed)) {
+					return
+				}
 			}
-			// Set the response signature
-			w.Header().Set("X-OpenGDPR-Signature", signature)
 		}
 		w.WriteHeader(s.respCode(r))
 		// write the response
-		w.Write(buf.Bytes())
+		w.Write(body)
 	}
 }
 
-func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.handlerFn(w, r) }
+func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.maxBodySize > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	}
+	s.handlerFn(w, r)
+}
 
 // Before applys any http.HandlerFunc to the request before
 // sending it on to the controller/processor. Note that if a
 // handler incecepts the body of the request via request.Body
 // they must ensure it's contents are added back to request
 // or signature verification will fail!
+//
+// Deprecated: Before cannot short-circuit the request, use Use.
 func (s *Server) Before(handlers ...http.HandlerFunc) {
-	original := s.handlerFn
-	s.handlerFn = func(w http.ResponseWriter, r *http.Request) {
-		for _, handler := range handlers {
-			handler(w, r)
-		}
-		original(w, r)
-	}
+	s.wrap(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, handler := range handlers {
+				handler(w, r)
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
 }
 
 // After applys any http.HandlerFunc to the request after
 // it has been handled by the controller/processor.
+//
+// Deprecated: After cannot see the response, use Use.
 func (s *Server) After(handlers ...http.HandlerFunc) {
-	original := s.handlerFn
-	s.handlerFn = func(w http.ResponseWriter, r *http.Request) {
-		original(w, r)
-		for _, handler := range handlers {
-			handler(w, r)
-		}
-	}
+	s.wrap(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			for _, handler := range handlers {
+				handler(w, r)
+			}
+		})
+	})
 }
 
 // NewServer returns a server type that statisfies the
 // http.Handler interface.
 func NewServer(opts *ServerOptions) *Server {
 	server := &Server{
-		signer:          opts.Signer,
-		verifier:        opts.Verifier,
-		isProcessor:     hasProcessor(opts),
-		isController:    hasController(opts),
-		headers:         http.Header{},
-		processorDomain: opts.ProcessorDomain,
+		signer:                  opts.Signer,
+		verifier:                opts.Verifier,
+		verifiers:               opts.Verifiers,
+		isProcessor:             hasProcessor(opts),
+		isController:            hasController(opts),
+		headers:                 http.Header{},
+		processorDomain:         opts.ProcessorDomain,
+		migrations:              migrations(opts),
+		submissions:             opts.Submissions,
+		replay:                  newReplayOptions(opts.Replay),
+		authenticator:           opts.Authenticator,
+		adminAuthenticator:      opts.AdminAuthenticator,
+		controllerAuthenticator: opts.ControllerAuthenticator,
+		limiter:                 newRateLimiter(opts.RateLimit),
+		hooks:                   opts.Hooks,
+		signerPolicy:            opts.SignerPolicy,
+		fallbackSigner:          opts.FallbackSigner,
+		unhealthy:               &atomic.Value{},
+		checksMu:                &sync.Mutex{},
+		checks:                  append(defaultChecks(opts), opts.HealthChecks...),
+		ready:                   &readyCache{},
+		metrics:                 opts.Metrics,
+		tracer:                  opts.Tracer,
+		logger:                  opts.Logger,
+		maxBodySize:             opts.MaxBodySize,
+	}
+	if server.maxBodySize == 0 {
+		server.maxBodySize = DefaultMaxBodySize
 	}
 	server.headers.Set("Accept", "application/json")
 	server.headers.Set("Content-Type", "application/json")
 	if hasProcessor(opts) {
-		server.headers.Set("X-OpenGDPR-ProcessorDomain", opts.ProcessorDomain)
+		server.headers.Set(LegacyProcessorDomainHeader, opts.ProcessorDomain)
 	}
 	router := httprouter.New()
 	hm := buildHandlerMap(opts)
 	for path, methods := range hm {
 		for method, builder := range methods {
-			router.Handle(method, path, server.handle(builder(opts)))
+			handle := server.handle(builder(opts))
+			if server.limiter != nil {
+				handle = server.rateLimit(method, path, handle)
+			}
+			if auth, ok := server.routeAuthenticator(path, method); ok {
+				handle = server.authenticate(auth, handle)
+			}
+			if server.limiter != nil {
+				handle = server.rateLimitIP(method, path, handle)
+			}
+			handle = server.recover(handle)
+			if server.metrics != nil {
+				handle = server.instrument(method, path, handle)
+			}
+			handle = server.trace(method, path, handle)
+			router.Handle(method, path, handle)
 		}
 	}
-	server.handlerFn = router.ServeHTTP
+	// Health endpoints unless overridden
+	if _, ok := hm["/healthz"]; !ok {
+		router.HandlerFunc("GET", "/healthz", server.healthz)
+	}
+	if _, ok := hm["/readyz"]; !ok {
+		router.HandlerFunc("GET", "/readyz", server.readyz)
+	}
+	server.router = router
+	server.Use(opts.Middleware...)
 	return server
 }
 
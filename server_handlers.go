@@ -3,6 +3,7 @@ package gdpr
 import (
 	"encoding/json"
 	"io"
+	"net/url"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -11,7 +12,10 @@ import (
 
 func getRequest(opts *ServerOptions) Handler {
 	return func(w io.Writer, _ io.Reader, p httprouter.Params) error {
-		resp, err := opts.Processor.Status(p.ByName("id"))
+		id := p.ByName("id")
+		resp, err := opts.Hooks.status(id, func() (*StatusResponse, error) {
+			return ownedStatus(opts.Processor, p.ByName(ControllerIdParam), id)
+		})
 		if err != nil {
 			return err
 		}
@@ -21,16 +25,84 @@ func getRequest(opts *ServerOptions) Handler {
 
 func postRequest(opts *ServerOptions) Handler {
 	validate := ValidateRequest(opts)
-	return func(w io.Writer, r io.Reader, _ httprouter.Params) error {
+	return func(w io.Writer, r io.Reader, p httprouter.Params) error {
 		req := &Request{}
-		err := json.NewDecoder(r).Decode(req)
+		err := decodeJSON(r, req, opts.StrictJSON)
 		if err != nil {
+			logEvent(opts.Logger, EventValidationFailed, map[string]interface{}{"error": err})
 			return err
 		}
+		logEvent(opts.Logger, EventRequestReceived, map[string]interface{}{
+			"subject_request_id":   req.SubjectRequestId,
+			"subject_request_type": req.SubjectRequestType,
+			"subject_identities":   req.SubjectIdentities,
+			"controller_id":        p.ByName(ControllerIdParam),
+		})
 		if err := validate(req); err != nil {
+			logEvent(opts.Logger, EventValidationFailed, map[string]interface{}{
+				"subject_request_id": req.SubjectRequestId,
+				"subject_identities": req.SubjectIdentities,
+				"error":              err,
+			})
 			return err
 		}
-		resp, err := opts.Processor.Request(req)
+		req.ControllerId = p.ByName(ControllerIdParam)
+		req.TraceParent = p.ByName(TraceParentParam)
+		resp, err := opts.Hooks.request(req, func() (*Response, error) {
+			return opts.Processor.Request(req)
+		})
+		if err != nil {
+			return err
+		}
+		if req.ControllerId != "" {
+			resp.ControllerId = req.ControllerId
+		}
+		incCounter(opts.Metrics, MetricSubmissions, map[string]string{"subject_type": string(req.SubjectRequestType)})
+		return json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func getRequests(opts *ServerOptions) Handler {
+	return func(w io.Writer, _ io.Reader, p httprouter.Params) error {
+		values, err := url.ParseQuery(p.ByName(QueryParam))
+		if err != nil {
+			return ErrInvalidParameter("query", err.Error())
+		}
+		listOpts, err := ParseListOptions(values)
+		if err != nil {
+			return err
+		}
+		resp, err := opts.Processor.(Lister).List(listOpts)
+		if err != nil {
+			return err
+		}
+		// Omit requests rejected by the status hooks
+		requests := make([]*ListedRequest, 0, len(resp.Requests))
+		for _, req := range resp.Requests {
+			_, err := opts.Hooks.status(req.SubjectRequestId, func() (*StatusResponse, error) {
+				return &StatusResponse{
+					SubjectRequestId:       req.SubjectRequestId,
+					RequestStatus:          req.RequestStatus,
+					ExpectedCompletionTime: req.ExpectedCompletionTime,
+				}, nil
+			})
+			if err == nil {
+				requests = append(requests, req)
+			}
+		}
+		resp.Requests = requests
+		return json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func postStatuses(opts *ServerOptions) Handler {
+	return func(w io.Writer, r io.Reader, p httprouter.Params) error {
+		req := &BatchStatusRequest{}
+		err := decodeJSON(r, req, opts.StrictJSON)
+		if err != nil {
+			return err
+		}
+		resp, err := batchStatus(opts.Processor, opts.Hooks, p.ByName(ControllerIdParam), req.SubjectRequestIds)
 		if err != nil {
 			return err
 		}
@@ -40,7 +112,15 @@ func postRequest(opts *ServerOptions) Handler {
 
 func deleteRequest(opts *ServerOptions) Handler {
 	return func(w io.Writer, _ io.Reader, p httprouter.Params) error {
-		resp, err := opts.Processor.Cancel(p.ByName("id"))
+		id := p.ByName("id")
+		resp, err := opts.Hooks.cancel(id, func() (*CancellationResponse, error) {
+			if controller := p.ByName(ControllerIdParam); controller != "" {
+				if _, err := ownedStatus(opts.Processor, controller, id); err != nil {
+					return nil, err
+				}
+			}
+			return opts.Processor.Cancel(id)
+		})
 		if err != nil {
 			return err
 		}
@@ -48,6 +128,27 @@ func deleteRequest(opts *ServerOptions) Handler {
 	}
 }
 
+// ownedStatus returns the status of a request unless it
+// was submitted by a controller other than the one
+// calling, in which case it is not found.
+func ownedStatus(proc Processor, controller, id string) (*StatusResponse, error) {
+	status, err := proc.Status(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ownedBy(status, controller) {
+		return nil, ErrNotFound(id)
+	}
+	return status, nil
+}
+
+// ownedBy reports whether the status may be seen by
+// the controller, any status may be seen when
+// controllers are not authenticated.
+func ownedBy(status *StatusResponse, controller string) bool {
+	return controller == "" || status.ControllerId == controller
+}
+
 // discovery
 
 func getDiscovery(opts *ServerOptions) Handler {
@@ -57,6 +158,7 @@ func getDiscovery(opts *ServerOptions) Handler {
 			SupportedSubjectRequestTypes: opts.SubjectTypes,
 			SupportedIdentities:          opts.Identities,
 			ProcessorCertificate:         opts.ProcessorCertificateUrl,
+			SupportedApiVersions:         SupportedVersions(opts),
 		}
 		return json.NewEncoder(w).Encode(resp)
 	}
@@ -65,13 +167,53 @@ func getDiscovery(opts *ServerOptions) Handler {
 // opengdpr_callbacks
 
 func postCallback(opts *ServerOptions) Handler {
-	return func(_ io.Writer, r io.Reader, _ httprouter.Params) error {
+	return func(_ io.Writer, r io.Reader, p httprouter.Params) error {
 		req := &CallbackRequest{}
-		err := json.NewDecoder(r).Decode(req)
+		err := decodeJSON(r, req, opts.StrictJSON)
 		if err != nil {
 			return err
 		}
-		return opts.Controller.Callback(req)
+		domain := p.ByName(ProcessorDomainParam)
+		req.ProcessorDomain = domain
+		logEvent(opts.Logger, EventCallbackReceived, map[string]interface{}{
+			"subject_request_id": req.SubjectRequestId,
+			"processor_domain":   domain,
+			"request_status":     req.RequestStatus,
+		})
+		return opts.Hooks.callback(req, func() error {
+			// Only pass callbacks which move the request
+			// forward in its lifecycle to the controller,
+			// the status is reverted if the controller fails
+			// so that the processor's retry is not ignored.
+			var revert func() error
+			switch {
+			case opts.Ledger != nil:
+				previous, advanced, err := recordStatus(opts.Ledger, domain, callbackStatus(req))
+				if err != nil || !advanced {
+					return err
+				}
+				revert = func() error { return revertStatus(opts.Ledger, previous, req.RequestStatus) }
+			case opts.CallbackStates != nil:
+				previous, advanced, err := opts.CallbackStates.Advance(domain, req.SubjectRequestId, req.RequestStatus)
+				if err != nil || !advanced {
+					return err
+				}
+				revert = func() error {
+					return opts.CallbackStates.Revert(domain, req.SubjectRequestId, req.RequestStatus, previous)
+				}
+			}
+			err := opts.Controller.Callback(req)
+			if err != nil && revert != nil {
+				if rerr := revert(); rerr != nil {
+					logEvent(opts.Logger, EventError, map[string]interface{}{
+						"subject_request_id": req.SubjectRequestId,
+						"processor_domain":   domain,
+						"error":              rerr,
+					})
+				}
+			}
+			return err
+		})
 	}
 }
 
@@ -91,9 +233,18 @@ func buildHandlerMap(opts *ServerOptions) HandlerMap {
 		hm["/opengdpr_requests"] = map[string]Builder{
 			"POST": postRequest,
 		}
+		if _, ok := opts.Processor.(Lister); ok && opts.Authenticator != nil {
+			hm["/opengdpr_requests"]["GET"] = getRequests
+		}
+		hm["/opengdpr_statuses"] = map[string]Builder{
+			"POST": postStatuses,
+		}
 		hm["/discovery"] = map[string]Builder{
 			"GET": getDiscovery,
 		}
+		if _, ok := opts.Processor.(Administrator); ok && opts.AdminAuthenticator != nil {
+			hm.Merge(adminHandlerMap())
+		}
 	}
 	if opts.HandlerMap != nil {
 		hm.Merge(opts.HandlerMap)
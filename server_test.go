@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -146,6 +149,7 @@ func TestServerDiscovery(t *testing.T) {
 	assert.Equal(t, IDENTITY_EMAIL, resp.SupportedIdentities[0].Type)
 	assert.Equal(t, FORMAT_RAW, resp.SupportedIdentities[0].Format)
 	assert.Equal(t, ApiVersion, resp.ApiVersion)
+	assert.Equal(t, []string{ApiVersion, "0.1"}, resp.SupportedApiVersions)
 }
 
 func TestServerError(t *testing.T) {
@@ -160,3 +164,260 @@ func TestServerError(t *testing.T) {
 	assert.Equal(t, 501, resp.Code)
 	assert.Equal(t, "Oh No!", resp.Message)
 }
+
+func TestServerApiVersion(t *testing.T) {
+	server, _ := newServer()
+	// Unsupported version
+	r := httptest.NewRequest("DELETE", "/opengdpr_requests/1234", nil)
+	r.Header.Set("GDPR-Version", "9.9")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 400, w.Code)
+	// Older version is downgraded
+	r = httptest.NewRequest("DELETE", "/opengdpr_requests/1234", nil)
+	r.Header.Set("GDPR-Version", "0.1")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0.1", w.Header().Get("GDPR-Version"))
+	resp := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Contains(t, resp, "ReceivedTime")
+	assert.Equal(t, "0.1", resp["api_version"])
+	// Version is read from the payload
+	body := bytes.Replace(mockRequestBody, []byte(`"1.0"`), []byte(`"0.3"`), 1)
+	r = httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestServerDuplicateRequest(t *testing.T) {
+	proc := &mockProcessor{response: &Response{SubjectRequestId: "a7551968-d5d6-44b2-9831-815ac9017798"}}
+	server := NewServer(&ServerOptions{
+		Signer:       MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}),
+		Processor:    proc,
+		Submissions:  NewMemorySubmissionStore(),
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+	})
+	r := httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+	signature := w.Header().Get("X-OpenGDPR-Signature")
+	body := w.Body.String()
+	// The processor must not be called again
+	proc.err = ErrorResponse{Code: 500, Message: "Oh No!"}
+	r = httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, signature, w.Header().Get("X-OpenGDPR-Signature"))
+	assert.Equal(t, body, w.Body.String())
+	// Same ID with a different payload
+	r = httptest.NewRequest("POST", "/opengdpr_requests",
+		bytes.NewBuffer(bytes.Replace(mockRequestBody, []byte("johndoe"), []byte("janedoe"), 1)))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 409, w.Code)
+}
+
+func TestServerDuplicateRequestVersions(t *testing.T) {
+	proc := &mockProcessor{response: &Response{
+		SubjectRequestId: "a7551968-d5d6-44b2-9831-815ac9017798",
+		ReceivedTime:     time.Date(2018, 10, 2, 15, 0, 1, 0, time.UTC),
+	}}
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		Submissions:  NewMemorySubmissionStore(),
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+	})
+	submit := func(version string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody))
+		r.Header.Set("GDPR-Version", version)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+	w := submit("0.1")
+	assert.Equal(t, 201, w.Code)
+	legacy := w.Body.String()
+	assert.Contains(t, legacy, `"ReceivedTime"`)
+	// Retries are replayed in the version of the retry
+	proc.err = ErrorResponse{Code: 500, Message: "Oh No!"}
+	w = submit(ApiVersion)
+	assert.Equal(t, 201, w.Code)
+	assert.Contains(t, w.Body.String(), `"received_time"`)
+	w = submit("0.1")
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, legacy, w.Body.String())
+}
+
+func TestMemorySubmissionStoreBound(t *testing.T) {
+	store := NewMemorySubmissionStore().(*memorySubmissionStore)
+	for i := 0; i <= MaxSubmissions; i++ {
+		sub := &Submission{SubjectRequestId: strconv.Itoa(i)}
+		claimed, err := store.Claim(sub)
+		assert.NoError(t, err)
+		assert.Nil(t, claimed)
+		assert.NoError(t, store.Put(sub))
+		// Replacing a submission does not count twice
+		assert.NoError(t, store.Put(sub))
+	}
+	assert.Len(t, store.submissions, MaxSubmissions)
+	sub, _ := store.Get("", "0")
+	assert.Nil(t, sub)
+	sub, _ = store.Get("", strconv.Itoa(MaxSubmissions))
+	assert.NotNil(t, sub)
+}
+
+type blockingProcessor struct {
+	mockProcessor
+	started, release chan struct{}
+	calls            int32
+}
+
+func (b *blockingProcessor) Request(req *Request) (*Response, error) {
+	atomic.AddInt32(&b.calls, 1)
+	b.started <- struct{}{}
+	<-b.release
+	return b.mockProcessor.Request(req)
+}
+
+func TestServerConcurrentDuplicateRequest(t *testing.T) {
+	proc := &blockingProcessor{
+		mockProcessor: mockProcessor{response: &Response{SubjectRequestId: "a7551968-d5d6-44b2-9831-815ac9017798"}},
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		Submissions:  NewMemorySubmissionStore(),
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+	})
+	submit := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+		return w
+	}
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- submit() }()
+	<-proc.started
+	// A retry while the first attempt is in flight
+	w := submit()
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.True(t, Retryable(&ErrorResponse{Code: w.Code}))
+	close(proc.release)
+	assert.Equal(t, 201, (<-first).Code)
+	assert.Equal(t, 201, submit().Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&proc.calls))
+	// Failed submissions release their claim
+	store := NewMemorySubmissionStore()
+	failing := &mockProcessor{err: ErrorResponse{Code: 500, Message: "Oh No!"}}
+	server = NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    failing,
+		Submissions:  store,
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+	})
+	assert.Equal(t, 500, submit().Code)
+	sub, _ := store.Get("", "a7551968-d5d6-44b2-9831-815ac9017798")
+	assert.Nil(t, sub)
+}
+
+func TestServerCallbackVerifiers(t *testing.T) {
+	controller := &mockController{}
+	server := NewServer(&ServerOptions{
+		Controller: controller,
+		Verifiers: map[string]Verifier{
+			"one.com": MustNewVerifier(&KeyOptions{KeyBytes: keyPairOne[1]}),
+			"two.com": MustNewVerifier(&KeyOptions{KeyBytes: keyPairTwo[1]}),
+		},
+	})
+	body, _ := json.Marshal(&CallbackRequest{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED})
+	signature, _ := MustNewSigner(&KeyOptions{KeyBytes: keyPairOne[0]}).Sign(body)
+	callback := func(header, domain string) int {
+		r := httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBuffer(body))
+		r.Header.Set("X-OpenGDPR-Signature", signature)
+		if header != "" {
+			r.Header.Set(header, domain)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, 200, callback(ProcessorDomainHeader, "one.com"))
+	assert.Equal(t, 200, callback(LegacyProcessorDomainHeader, "one.com"))
+	// Signed by another processor
+	assert.Equal(t, 403, callback(ProcessorDomainHeader, "two.com"))
+	// Unknown processors
+	assert.Equal(t, 403, callback(ProcessorDomainHeader, "evil.com"))
+	assert.Equal(t, 403, callback("", ""))
+	assert.Len(t, controller.callbacks, 2)
+}
+
+func TestServerMaxBodySize(t *testing.T) {
+	server := NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    &mockProcessor{response: &Response{SubjectRequestId: "1234"}},
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		MaxBodySize:  int64(len(mockRequestBody)),
+	})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(mockRequestBody)))
+	assert.Equal(t, 201, w.Code)
+	body := append(append([]byte{}, mockRequestBody...), ' ')
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(body)))
+	assert.Equal(t, 413, w.Code)
+	// Controllers read the body before verifying it
+	controller := NewServer(&ServerOptions{Controller: &mockController{}, Verifier: NoopVerifier{}, MaxBodySize: 8})
+	w = httptest.NewRecorder()
+	controller.ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_callbacks", bytes.NewBufferString(`{"subject_request_id":"1234"}`)))
+	assert.Equal(t, 413, w.Code)
+}
+
+func TestServerStrictJSON(t *testing.T) {
+	newStrictServer := func(strict bool) *Server {
+		return NewServer(&ServerOptions{
+			Signer:       NoopSigner{},
+			Processor:    &mockProcessor{response: &Response{SubjectRequestId: "1234"}},
+			SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+			Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+			StrictJSON:   strict,
+		})
+	}
+	unknown := bytes.Replace(mockRequestBody, []byte(`"api_version"`), []byte(`"unknown": true, "api_version"`), 1)
+	trailing := append(append([]byte{}, mockRequestBody...), []byte(`{}`)...)
+	for _, test := range []struct {
+		body   []byte
+		strict bool
+		code   int
+	}{
+		{mockRequestBody, true, 201},
+		{unknown, false, 201},
+		{unknown, true, 400},
+		{trailing, false, 201},
+		{trailing, true, 400},
+		{[]byte(`{"subject_request_id":`), false, 400},
+		{[]byte(`{"subject_request_type":"unknown"}`), false, 400},
+		{[]byte(``), false, 400},
+	} {
+		w := httptest.NewRecorder()
+		newStrictServer(test.strict).ServeHTTP(w, httptest.NewRequest("POST", "/opengdpr_requests", bytes.NewBuffer(test.body)))
+		assert.Equal(t, test.code, w.Code, string(test.body))
+		if test.code == 400 {
+			resp := ErrorResponse{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Contains(t, resp.Message, "invalid json")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package gdpr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Submission is a record of a Request accepted by
+// a processor along with the exact response that
+// was returned to the controller.
+type Submission struct {
+	SubjectRequestId string
+	// Identity of the controller which made the
+	// submission when controllers are authenticated,
+	// each controller has it's own submissions.
+	ControllerId string
+	// Hex encoded SHA256 digest of the normalized
+	// Request payload in the current ApiVersion.
+	Digest string
+	// Response body in the current ApiVersion and it's
+	// signature when the request was first accepted.
+	// Signature is empty if the body has not been signed
+	// yet or was accepted in another version.
+	Body      []byte
+	Signature string
+	// Set while the original submission
+	// is still being processed.
+	Pending bool
+}
+
+// SubmissionStore records accepted submissions so
+// the Server can detect controllers retrying
+// the same request.
+type SubmissionStore interface {
+	// Get returns the Submission of the given controller
+	// and subject_request_id or nil if none exists.
+	Get(controllerId, id string) (*Submission, error)
+	// Claim atomically records a Pending Submission unless
+	// one already exists for it's controller and id. It
+	// returns nil if the claim was made, otherwise
+	// the existing Submission.
+	Claim(sub *Submission) (*Submission, error)
+	// Put records the response of a
+	// claimed Submission.
+	Put(sub *Submission) error
+	// Release removes a Pending Submission which
+	// was not accepted so it may be retried.
+	Release(controllerId, id string) error
+}
+
+// MaxSubmissions is the number of accepted submissions
+// kept by the memory SubmissionStore, the oldest are
+// discarded first.
+const MaxSubmissions = 10000
+
+// NewMemorySubmissionStore returns a SubmissionStore
+// which keeps the most recent MaxSubmissions
+// submissions in memory.
+func NewMemorySubmissionStore() SubmissionStore {
+	return &memorySubmissionStore{submissions: map[submissionKey]*Submission{}}
+}
+
+type submissionKey struct {
+	controllerId, id string
+}
+
+type memorySubmissionStore struct {
+	mu          sync.RWMutex
+	submissions map[submissionKey]*Submission
+	// accepted submissions, oldest first
+	order []submissionKey
+}
+
+func (m *memorySubmissionStore) Get(controllerId, id string) (*Submission, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.submissions[submissionKey{controllerId, id}], nil
+}
+
+func (m *memorySubmissionStore) Claim(sub *Submission) (*Submission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := submissionKey{sub.ControllerId, sub.SubjectRequestId}
+	if existing, ok := m.submissions[key]; ok {
+		return existing, nil
+	}
+	claimed := *sub
+	claimed.Pending = true
+	m.submissions[key] = &claimed
+	return nil, nil
+}
+
+func (m *memorySubmissionStore) Release(controllerId, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := submissionKey{controllerId, id}
+	if sub, ok := m.submissions[key]; ok && sub.Pending {
+		delete(m.submissions, key)
+	}
+	return nil
+}
+
+func (m *memorySubmissionStore) Put(sub *Submission) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := submissionKey{sub.ControllerId, sub.SubjectRequestId}
+	if existing, ok := m.submissions[key]; !ok || existing.Pending {
+		m.order = append(m.order, key)
+	}
+	m.submissions[key] = sub
+	if len(m.order) > MaxSubmissions {
+		delete(m.submissions, m.order[0])
+		m.order = m.order[1:]
+	}
+	return nil
+}
+
+// isSubmission checks if the request submits
+// a new GDPR request to a processor.
+func isSubmission(r *http.Request) bool {
+	return r.URL.Path == "/opengdpr_requests" && r.Method == "POST"
+}
+
+// submissionDigest decodes a raw Request payload and
+// returns it's subject_request_id along with a digest
+// of the normalized payload so that differences in
+// whitespace or field ordering are ignored.
+func submissionDigest(raw []byte) (string, string, error) {
+	req := &Request{}
+	if err := json.Unmarshal(raw, req); err != nil {
+		return "", "", err
+	}
+	normalized, err := json.Marshal(req)
+	if err != nil {
+		return "", "", err
+	}
+	hashed := sha256.Sum256(normalized)
+	return req.SubjectRequestId, hex.EncodeToString(hashed[:]), nil
+}
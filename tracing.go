@@ -0,0 +1,212 @@
+package gdpr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TraceParentHeader carries the W3C trace context.
+const TraceParentHeader = "traceparent"
+
+// TraceParentParam is the key of the httprouter.Params
+// entry holding the traceparent of the span serving
+// a request.
+const TraceParentParam = "traceparent"
+
+// TraceContext identifies a span within a trace
+// as described by the W3C Trace Context specification.
+type TraceContext struct {
+	TraceId string
+	SpanId  string
+	Sampled bool
+}
+
+// String encodes the TraceContext as a traceparent header.
+func (t TraceContext) String() string {
+	flags := "00"
+	if t.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceId, t.SpanId, flags)
+}
+
+// isHex reports whether s is n lowercase hex
+// characters which are not all zero.
+func isHex(s string, n int) bool {
+	return lowerHex(s, n) && strings.Trim(s, "0") != ""
+}
+
+// lowerHex reports whether s is n lowercase
+// hex characters.
+func lowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTraceParent decodes a traceparent header.
+func ParseTraceParent(header string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || !lowerHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, fmt.Errorf("bad traceparent: %s", header)
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) {
+		return TraceContext{}, fmt.Errorf("bad traceparent: %s", header)
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 {
+		return TraceContext{}, fmt.Errorf("bad traceparent: %s", header)
+	}
+	return TraceContext{TraceId: parts[1], SpanId: parts[2], Sampled: flags&1 == 1}, nil
+}
+
+func randomHex(n int) string {
+	raw := make([]byte, n)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+type traceKey struct{}
+
+// ContextWithTrace returns a context carrying the
+// TraceContext which Client calls are made within.
+func ContextWithTrace(ctx context.Context, trace TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// TraceFromContext returns the TraceContext
+// carried by ctx if any.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceKey{}).(TraceContext)
+	return trace, ok
+}
+
+// Span is a single operation within a trace.
+type Span struct {
+	Name     string
+	TraceId  string
+	SpanId   string
+	ParentId string
+	Sampled  bool
+	Start    time.Time
+	End      time.Time
+	// Attributes such as the route and status code.
+	Attributes map[string]string
+	// Error which caused the operation to fail if any.
+	Err error
+}
+
+// Context returns the TraceContext
+// identifying the span.
+func (s *Span) Context() TraceContext {
+	return TraceContext{TraceId: s.TraceId, SpanId: s.SpanId, Sampled: s.Sampled}
+}
+
+// Tracer is notified as spans start and end and may
+// export them to a tracing system. Implementations must
+// be safe for concurrent use, see SpanRecorder.
+type Tracer interface {
+	SpanStarted(span *Span)
+	SpanEnded(span *Span)
+}
+
+// startSpan begins a new span which is a child of parent
+// or the root of a new trace if parent is nil.
+func startSpan(tracer Tracer, name string, parent *TraceContext) *Span {
+	span := &Span{
+		Name:       name,
+		SpanId:     randomHex(8),
+		Sampled:    true,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+	}
+	if parent != nil {
+		span.TraceId = parent.TraceId
+		span.ParentId = parent.SpanId
+		span.Sampled = parent.Sampled
+	} else {
+		span.TraceId = randomHex(16)
+	}
+	if tracer != nil {
+		tracer.SpanStarted(span)
+	}
+	return span
+}
+
+// endSpan completes the span.
+func endSpan(tracer Tracer, span *Span, err error) {
+	span.End = time.Now()
+	span.Err = err
+	if tracer != nil {
+		tracer.SpanEnded(span)
+	}
+}
+
+// SpanRecorder is a Tracer which keeps
+// every ended span in memory for tests.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// NewSpanRecorder returns a new SpanRecorder.
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+func (r *SpanRecorder) SpanStarted(*Span) {}
+
+func (r *SpanRecorder) SpanEnded(span *Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Spans returns every ended span in the order they ended.
+func (r *SpanRecorder) Spans() []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Span{}, r.spans...)
+}
+
+// trace starts a span for each request served by a route
+// continuing any trace given in the TraceParentHeader. The
+// span's traceparent is passed to the handler as the
+// TraceParentParam.
+func (s *Server) trace(method, path string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		var parent *TraceContext
+		if trace, err := ParseTraceParent(r.Header.Get(TraceParentHeader)); err == nil {
+			parent = &trace
+		}
+		span := startSpan(s.tracer, method+" "+path, parent)
+		span.Attributes["http.method"] = method
+		span.Attributes["http.route"] = path
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r, append(p, httprouter.Param{Key: TraceParentParam, Value: span.Context().String()}))
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		span.Attributes["http.status_code"] = strconv.Itoa(sw.code)
+		var err error
+		if sw.code >= 500 {
+			err = fmt.Errorf("%s", http.StatusText(sw.code))
+		}
+		endSpan(s.tracer, span, err)
+	}
+}
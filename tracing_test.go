@@ -0,0 +1,102 @@
+package gdpr
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	trace, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.TraceId)
+	assert.Equal(t, "00f067aa0ba902b7", trace.SpanId)
+	assert.True(t, trace.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", trace.String())
+	for _, header := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"0G-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ParseTraceParent(header)
+		assert.Error(t, err, header)
+	}
+	// Future versions may append fields
+	_, err = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.NoError(t, err)
+}
+
+func TestTracePropagation(t *testing.T) {
+	controllerSpans, processorSpans := NewSpanRecorder(), NewSpanRecorder()
+	controller := &mockController{}
+	ctrlSvr := httptest.NewServer(NewServer(&ServerOptions{
+		Controller: controller,
+		Verifier:   NoopVerifier{},
+		Tracer:     controllerSpans,
+	}))
+	defer ctrlSvr.Close()
+	proc := &recordingProcessor{}
+	procSvr := httptest.NewServer(NewServer(&ServerOptions{
+		Signer:       NoopSigner{},
+		Processor:    proc,
+		SubjectTypes: []SubjectType{SUBJECT_ERASURE},
+		Identities:   []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW}},
+		Tracer:       processorSpans,
+	}))
+	defer procSvr.Close()
+	client := NewClient(&ClientOptions{Endpoint: procSvr.URL, Verifier: NoopVerifier{}, Tracer: controllerSpans})
+	root := TraceContext{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7", Sampled: true}
+	_, err := client.Request(ContextWithTrace(context.Background(), root), &Request{
+		SubjectRequestId:   "1234",
+		SubjectRequestType: SUBJECT_ERASURE,
+		SubjectIdentities:  []Identity{Identity{Type: IDENTITY_EMAIL, Format: FORMAT_RAW, Value: "johndoe@example.com"}},
+	})
+	assert.NoError(t, err)
+	// The processor sends a callback within the
+	// trace of the original request
+	assert.Len(t, proc.requests, 1)
+	err = Callback(&CallbackRequest{
+		SubjectRequestId:  "1234",
+		RequestStatus:     STATUS_COMPLETED,
+		StatusCallbackUrl: ctrlSvr.URL + "/opengdpr_callbacks",
+		TraceParent:       proc.requests[0].TraceParent,
+	}, &CallbackOptions{MaxAttempts: 1, Signer: NoopSigner{}, Tracer: processorSpans})
+	assert.NoError(t, err)
+	ctrl, procs := controllerSpans.Spans(), processorSpans.Spans()
+	assert.Len(t, ctrl, 2)
+	assert.Len(t, procs, 2)
+	clientSpan, serverSpan, callbackSpan, receivedSpan := ctrl[0], procs[0], procs[1], ctrl[1]
+	assert.Equal(t, "POST /opengdpr_requests", clientSpan.Name)
+	assert.Equal(t, root.SpanId, clientSpan.ParentId)
+	assert.Equal(t, clientSpan.SpanId, serverSpan.ParentId)
+	assert.Equal(t, "201", serverSpan.Attributes["http.status_code"])
+	assert.Equal(t, serverSpan.SpanId, callbackSpan.ParentId)
+	assert.Equal(t, callbackSpan.SpanId, receivedSpan.ParentId)
+	assert.Equal(t, "POST /opengdpr_callbacks", receivedSpan.Name)
+	for _, span := range []*Span{clientSpan, serverSpan, callbackSpan, receivedSpan} {
+		assert.Equal(t, root.TraceId, span.TraceId)
+		assert.NoError(t, span.Err)
+	}
+}
+
+func TestCallbackSpanBadUrl(t *testing.T) {
+	spans := NewSpanRecorder()
+	metrics := NewPrometheusMetrics()
+	cb := &CallbackRequest{SubjectRequestId: "1234", RequestStatus: STATUS_COMPLETED, StatusCallbackUrl: "://bad"}
+	assert.Error(t, Callback(cb, &CallbackOptions{
+		MaxAttempts: 1,
+		Signer:      NoopSigner{},
+		Tracer:      spans,
+		Metrics:     metrics,
+	}))
+	assert.Len(t, spans.Spans(), 1)
+	assert.Error(t, spans.Spans()[0].Err)
+	assert.Contains(t, string(metrics.Format()), `gdpr_callbacks_total{result="failure"} 1`+"\n")
+}
@@ -8,7 +8,9 @@ import (
 	"time"
 )
 
-const ApiVersion = "0.1"
+// ApiVersion is the version of the OpenGDPR
+// specification implemented by this library.
+const ApiVersion = "1.0"
 
 // SubjectType is the type of request
 // that is being made.
@@ -136,6 +138,31 @@ func (r RequestStatus) Valid() bool {
 	return ok
 }
 
+// Finished reports whether the status is terminal
+// and will not change again.
+func (r RequestStatus) Finished() bool {
+	return r == STATUS_COMPLETED || r == STATUS_CANCELLED
+}
+
+// stage returns the position of the status
+// in the lifecycle of a request.
+func (r RequestStatus) stage() int {
+	switch r {
+	case STATUS_IN_PROGRESS:
+		return 1
+	case STATUS_COMPLETED, STATUS_CANCELLED:
+		return 2
+	}
+	return 0
+}
+
+// Precedes reports whether r comes before next in the
+// lifecycle of a request, i.e. a transition from r to
+// next moves the request forward.
+func (r RequestStatus) Precedes(next RequestStatus) bool {
+	return r.stage() < next.stage()
+}
+
 func (r *RequestStatus) UnmarshalJSON(raw []byte) error {
 	str := strings.Replace(string(raw), "\"", "", -1)
 	if _, ok := RequestStatusMap[str]; !ok {
@@ -161,6 +188,15 @@ type Request struct {
 	SubjectIdentities  []Identity  `json:"subject_identities"`
 	// TODO
 	Extensions json.RawMessage `json:"extensions"`
+	// Identity of the controller which submitted the
+	// request when the Server is configured with a
+	// ControllerAuthenticator, it is not serialized.
+	ControllerId string `json:"-"`
+	// W3C traceparent of the span in which the Server
+	// received the request, it is not serialized. It
+	// may be copied to the CallbackRequest of the
+	// request to continue the trace.
+	TraceParent string `json:"-"`
 }
 
 func (r Request) Base64() string {
@@ -181,6 +217,7 @@ type DiscoveryResponse struct {
 	SupportedIdentities          []Identity    `json:"supported_identities"`
 	SupportedSubjectRequestTypes []SubjectType `json:"supported_subject_request_types"`
 	ProcessorCertificate         string        `json:"processor_certificate"`
+	SupportedApiVersions         []string      `json:"supported_api_versions,omitempty"`
 }
 
 type StatusResponse struct {
@@ -206,6 +243,9 @@ type ErrorResponse struct {
 	Code    int     `json:"-"`
 	Message string  `json:"-"`
 	Errors  []Error `json:"-"`
+	// set when decoded from a spec error body
+	// returned by a remote server
+	remote bool
 }
 
 func (e *ErrorResponse) UnmarshalJSON(raw []byte) error {
@@ -255,12 +295,42 @@ type CallbackRequest struct {
 	SubjectRequestId       string        `json:"subject_request_id"`
 	RequestStatus          RequestStatus `json:"request_status"`
 	ResultsUrl             string        `json:"results_url"`
+	// Optional W3C traceparent the callback is sent
+	// within, see Request.TraceParent. It is sent as
+	// the TraceParentHeader rather than serialized.
+	TraceParent string `json:"-"`
+	// Domain of the processor which sent the callback
+	// as verified by the Server, it is not serialized.
+	ProcessorDomain string `json:"-"`
 }
 
+// CancellationResponse is the response to a cancelled
+// request. Since ApiVersion 1.0 the received time is
+// encoded as "received_time" rather than the "ReceivedTime"
+// written by the 0.1 release. Peers which send a GDPR-Version
+// header are migrated automatically, older peers which do not
+// will receive "received_time" and must be upgraded.
 type CancellationResponse struct {
 	ControllerId     string    `json:"controller_id"`
 	SubjectRequestId string    `json:"subject_request_id"`
-	ReceivedTime     time.Time `json:"ReceivedTime"`
+	ReceivedTime     time.Time `json:"received_time"`
 	EncodedRequest   string    `json:"encoded_request"`
 	ApiVersion       string    `json:"api_version"`
 }
+
+// UnmarshalJSON also accepts the "ReceivedTime" key written
+// by processors which do not send a GDPR-Version header.
+func (c *CancellationResponse) UnmarshalJSON(raw []byte) error {
+	type cancellation CancellationResponse
+	payload := struct {
+		*cancellation
+		LegacyReceivedTime *time.Time `json:"ReceivedTime"`
+	}{cancellation: (*cancellation)(c)}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+	if payload.LegacyReceivedTime != nil && c.ReceivedTime.IsZero() {
+		c.ReceivedTime = *payload.LegacyReceivedTime
+	}
+	return nil
+}
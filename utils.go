@@ -1,5 +1,47 @@
 package gdpr
 
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// DefaultMaxBodySize is used when
+// ServerOptions.MaxBodySize is unset.
+const DefaultMaxBodySize = 1 << 20
+
+// decodeJSON decodes a single JSON value from r into v,
+// malformed payloads are returned as ErrInvalidJSON. When
+// strict is set unknown fields and trailing data are
+// also rejected.
+func decodeJSON(r io.Reader, v interface{}, strict bool) error {
+	decoder := json.NewDecoder(r)
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.As(err, &tooLarge):
+		return err
+	case err == io.EOF:
+		return ErrInvalidJSON("empty body")
+	default:
+		if _, ok := err.(ErrorResponse); ok {
+			return err
+		}
+		return ErrInvalidJSON(err.Error())
+	}
+	if strict {
+		if _, err := decoder.Token(); err != io.EOF {
+			return ErrInvalidJSON("unexpected data after json value")
+		}
+	}
+	return nil
+}
+
 // SupportedFunc returns a function that checks if the server can
 // support a specific request.
 func SupportedFunc(opts *ServerOptions) func(*Request) error {
@@ -24,9 +66,19 @@ func SupportedFunc(opts *ServerOptions) func(*Request) error {
 	}
 }
 
+// ValidateRequest returns a function that checks a
+// Request has all required fields and can be supported
+// by the server.
 func ValidateRequest(opts *ServerOptions) func(*Request) error {
 	fn := SupportedFunc(opts)
+	versions := map[string]bool{}
+	for _, version := range SupportedVersions(opts) {
+		versions[version] = true
+	}
 	return func(req *Request) error {
+		if req.ApiVersion != "" && !versions[req.ApiVersion] {
+			return ErrUnsupportedApiVersion(req.ApiVersion)
+		}
 		if req.SubjectRequestId == "" {
 			return ErrMissingRequiredField("subject_request_id")
 		}
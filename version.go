@@ -0,0 +1,149 @@
+package gdpr
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration converts raw JSON payloads between a
+// specific version of the OpenGDPR specification
+// and the current ApiVersion.
+type Migration interface {
+	// Upgrade converts a payload sent by a peer
+	// speaking an older version into the shape
+	// expected by ApiVersion.
+	Upgrade(raw []byte) ([]byte, error)
+	// Downgrade converts a payload in the shape
+	// of ApiVersion into one understood by a peer
+	// speaking an older version.
+	Downgrade(raw []byte) ([]byte, error)
+}
+
+// DefaultMigrations are the versions supported by
+// a Server in addition to ApiVersion when no
+// migrations are configured.
+var DefaultMigrations = map[string]Migration{
+	"0.1": v01Migration{},
+}
+
+// v01Migration maps payloads produced by the 0.1
+// release of this library which encoded the
+// received time of a CancellationResponse as
+// "ReceivedTime".
+type v01Migration struct{}
+
+func (v01Migration) Upgrade(raw []byte) ([]byte, error) {
+	return rewriteFields(raw, "0.1", ApiVersion, map[string]string{
+		"ReceivedTime": "received_time",
+	})
+}
+
+func (v01Migration) Downgrade(raw []byte) ([]byte, error) {
+	return rewriteFields(raw, ApiVersion, "0.1", map[string]string{
+		"received_time": "ReceivedTime",
+	})
+}
+
+// rewriteFields renames the top level keys of a JSON
+// object and replaces its api_version. Payloads which
+// are not JSON objects are returned unmodified.
+func rewriteFields(raw []byte, from, to string, fields map[string]string) ([]byte, error) {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return raw, nil
+	}
+	for old, renamed := range fields {
+		if value, ok := obj[old]; ok {
+			delete(obj, old)
+			obj[renamed] = value
+		}
+	}
+	if value, ok := obj["api_version"]; ok {
+		var version string
+		if json.Unmarshal(value, &version) == nil && version == from {
+			obj["api_version"], _ = json.Marshal(to)
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// payloadVersion returns the api_version field of
+// a raw JSON payload if one is present.
+func payloadVersion(raw []byte) string {
+	payload := struct {
+		ApiVersion string `json:"api_version"`
+	}{}
+	if len(raw) == 0 || json.Unmarshal(raw, &payload) != nil {
+		return ""
+	}
+	return payload.ApiVersion
+}
+
+// compareVersions compares two dotted version strings
+// numerically returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortVersions sorts versions from newest to oldest.
+func sortVersions(versions []string) []string {
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) > 0
+	})
+	return versions
+}
+
+// NegotiateVersion returns the newest version supported
+// by both the local and remote peers.
+func NegotiateVersion(local, remote []string) (string, error) {
+	supported := map[string]bool{}
+	for _, version := range remote {
+		supported[version] = true
+	}
+	for _, version := range sortVersions(append([]string{}, local...)) {
+		if supported[version] {
+			return version, nil
+		}
+	}
+	return "", ErrUnsupportedApiVersion(strings.Join(remote, ","))
+}
+
+// migrations returns the configured migrations
+// or DefaultMigrations if none are set.
+func migrations(opts *ServerOptions) map[string]Migration {
+	if opts.Migrations == nil {
+		return DefaultMigrations
+	}
+	return opts.Migrations
+}
+
+// SupportedVersions returns every version of the
+// specification a server configured with opts
+// can accept ordered from newest to oldest.
+func SupportedVersions(opts *ServerOptions) []string {
+	versions := []string{ApiVersion}
+	for version := range migrations(opts) {
+		if version != ApiVersion {
+			versions = append(versions, version)
+		}
+	}
+	return sortVersions(versions)
+}
@@ -0,0 +1,32 @@
+package gdpr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	version, err := NegotiateVersion([]string{"0.1", "1.0"}, []string{"1.0", "0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0", version)
+	version, err = NegotiateVersion([]string{"1.0", "0.1"}, []string{"0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1", version)
+	_, err = NegotiateVersion([]string{"1.0"}, []string{"2.0"})
+	assert.Error(t, err)
+}
+
+func TestMigration(t *testing.T) {
+	migration := DefaultMigrations["0.1"]
+	raw, err := migration.Upgrade([]byte(`{"api_version":"0.1","ReceivedTime":"2018-10-02T15:00:01Z"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"api_version":"1.0","received_time":"2018-10-02T15:00:01Z"}`, string(raw))
+	raw, err = migration.Downgrade(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ReceivedTime":"2018-10-02T15:00:01Z","api_version":"0.1"}`, string(raw))
+	// Non-object payloads are left untouched
+	raw, err = migration.Upgrade([]byte(`[1,2]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `[1,2]`, string(raw))
+}
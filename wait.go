@@ -0,0 +1,84 @@
+package gdpr
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultMinPollInterval is the default initial
+	// interval between status polls.
+	DefaultMinPollInterval = 5 * time.Second
+	// DefaultMaxPollInterval is the default upper
+	// bound on the interval between status polls.
+	DefaultMaxPollInterval = time.Hour
+)
+
+// WaitOptions configure how WaitForCompletion
+// polls the status of a request.
+type WaitOptions struct {
+	// Initial interval between polls which is doubled
+	// after each poll, defaults to DefaultMinPollInterval.
+	MinInterval time.Duration
+	// Upper bound on the interval between polls,
+	// defaults to DefaultMaxPollInterval.
+	MaxInterval time.Duration
+	// Optional function called with every
+	// StatusResponse received.
+	OnStatus func(*StatusResponse)
+}
+
+// nextPoll returns the delay before the next poll. Polls
+// are not made before the expected completion time of
+// the request as the status is unlikely to change.
+func (o *WaitOptions) nextPoll(interval time.Duration, expected time.Time) time.Duration {
+	if !expected.IsZero() {
+		if until := time.Until(expected); until > interval {
+			interval = until
+		}
+	}
+	if interval > o.MaxInterval {
+		interval = o.MaxInterval
+	}
+	return interval
+}
+
+// WaitForCompletion polls the status of a request until it is
+// completed or cancelled returning the final StatusResponse.
+// Transient errors are ignored and polling continues until
+// the context is done.
+func (c *Client) WaitForCompletion(ctx context.Context, id string, opts *WaitOptions) (*StatusResponse, error) {
+	wait := WaitOptions{}
+	if opts != nil {
+		wait = *opts
+	}
+	if wait.MinInterval == 0 {
+		wait.MinInterval = DefaultMinPollInterval
+	}
+	if wait.MaxInterval == 0 {
+		wait.MaxInterval = DefaultMaxPollInterval
+	}
+	interval := wait.MinInterval
+	var expected time.Time
+	for {
+		resp, err := c.Status(ctx, id)
+		if err != nil && !Retryable(err) {
+			return nil, err
+		}
+		if err == nil {
+			if wait.OnStatus != nil {
+				wait.OnStatus(resp)
+			}
+			if resp.RequestStatus.Finished() {
+				return resp, nil
+			}
+			expected = resp.ExpectedCompletionTime
+		}
+		if err := sleepContext(ctx, wait.nextPoll(interval, expected)); err != nil {
+			return nil, err
+		}
+		if interval < wait.MaxInterval {
+			interval *= 2
+		}
+	}
+}
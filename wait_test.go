@@ -0,0 +1,60 @@
+package gdpr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func statusBody(status RequestStatus, expected time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"subject_request_id":"1234","request_status":"%s","expected_completion_time":"%s"}`,
+		status, expected.Format(time.RFC3339Nano)))
+}
+
+func TestWaitForCompletion(t *testing.T) {
+	expected := time.Now().Add(50 * time.Millisecond)
+	caller := &sequenceCaller{responses: []*http.Response{
+		newResponse(200, statusBody(STATUS_PENDING, expected)),
+		nil,
+		newResponse(503, []byte("unavailable")),
+		newResponse(200, statusBody(STATUS_IN_PROGRESS, expected)),
+		newResponse(200, statusBody(STATUS_COMPLETED, expected)),
+	}}
+	c := NewClient(&ClientOptions{Verifier: NoopVerifier{}, Caller: caller})
+	var statuses []RequestStatus
+	start := time.Now()
+	resp, err := c.WaitForCompletion(context.Background(), "1234", &WaitOptions{
+		MinInterval: time.Millisecond,
+		MaxInterval: 100 * time.Millisecond,
+		OnStatus: func(resp *StatusResponse) {
+			statuses = append(statuses, resp.RequestStatus)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, STATUS_COMPLETED, resp.RequestStatus)
+	assert.Equal(t, []RequestStatus{STATUS_PENDING, STATUS_IN_PROGRESS, STATUS_COMPLETED}, statuses)
+	assert.Equal(t, 5, caller.calls)
+	// Polling waits for the expected completion time
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestWaitForCompletionDeadline(t *testing.T) {
+	c := NewClient(&ClientOptions{
+		Verifier: NoopVerifier{},
+		Caller: CallerFunc(func(*http.Request) (*http.Response, error) {
+			return newResponse(200, statusBody(STATUS_PENDING, time.Time{})), nil
+		}),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.WaitForCompletion(ctx, "1234", &WaitOptions{MinInterval: time.Millisecond})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// Errors from the server are returned
+	c.caller = &sequenceCaller{responses: []*http.Response{newResponse(404, []byte("not found"))}}
+	_, err = c.WaitForCompletion(context.Background(), "1234", nil)
+	assert.Equal(t, 404, err.(*ErrorResponse).Code)
+}